@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxAccessTokenLifetime caps how far in the future an access token may expire.
+const maxAccessTokenLifetime = 365 * 24 * time.Hour
+
+func (app *Config) ListAccessTokens(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	tokens, err := app.Models.AccessToken.GetAllForUser(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    tokens,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateAccessToken issues a personal access token for the logged in user. A token
+// can only be given scopes the user's role grants. The plain text token is only
+// ever returned here.
+func (app *Config) CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	name := strings.TrimSpace(requestPayload.Name)
+	if name == "" || len(name) > 100 {
+		app.errorJSON(w, errors.New("name must be between 1 and 100 characters"), http.StatusBadRequest)
+		return
+	}
+
+	if len(requestPayload.Scopes) == 0 {
+		app.errorJSON(w, errors.New("at least one scope is required"), http.StatusBadRequest)
+		return
+	}
+
+	for _, scope := range requestPayload.Scopes {
+		if !data.HasPermission(claims.Permissions, scope) {
+			app.errorJSON(w, fmt.Errorf("scope %q is not allowed", scope), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var expiresAt time.Time
+	if requestPayload.ExpiresInDays < 0 {
+		app.errorJSON(w, errors.New("expires_in_days can't be negative"), http.StatusBadRequest)
+		return
+	}
+	if requestPayload.ExpiresInDays > 0 {
+		lifetime := time.Duration(requestPayload.ExpiresInDays) * 24 * time.Hour
+		if lifetime > maxAccessTokenLifetime {
+			app.errorJSON(w, fmt.Errorf("tokens can't last longer than %d days", int(maxAccessTokenLifetime.Hours()/24)), http.StatusBadRequest)
+			return
+		}
+		expiresAt = time.Now().Add(lifetime)
+	}
+
+	// the token works in the workspace the user is in now
+	token, plainText, err := app.Models.AccessToken.Insert(claims.UserID, name, requestPayload.Scopes, claims.WorkspaceID, expiresAt)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), claims.UserID, "authentication", fmt.Sprintf("%s created access token %q", claims.Email, name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Access token created. Copy it now, it won't be shown again",
+		Data: map[string]interface{}{
+			"token":        plainText,
+			"access_token": token,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) RevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid token id"), http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	err = app.Models.AccessToken.Revoke(id, claims.UserID)
+	if err != nil {
+		if errors.Is(err, data.ErrTokenNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), claims.UserID, "authentication", fmt.Sprintf("%s revoked access token %d", claims.Email, id))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Access token revoked",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// IntrospectAccessToken is called by the broker when a request carries a personal
+// access token instead of a JWT. It returns the same claims an access token would
+// carry, limited to the token's scopes and to what the user's role still grants.
+func (app *Config) IntrospectAccessToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := app.introspect(requestPayload.Token)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Valid token",
+		Data:    claims,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// introspect checks a personal access token and returns the claims it stands for:
+// the scopes the user still holds, in the workspace the token was made in.
+func (app *Config) introspect(tokenString string) (*Claims, error) {
+	token, err := app.Models.AccessToken.Authenticate(tokenString)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	user, err := app.Models.User.GetOne(token.UserID)
+	if err != nil || user.Active != 1 {
+		return nil, errInvalidToken
+	}
+
+	// tokens made before workspaces existed work in the user's first workspace
+	var membership *data.Membership
+	if token.WorkspaceID == 0 {
+		membership, err = app.workspaceFor(user, 0)
+	} else {
+		membership, err = app.Models.Workspace.Membership(token.WorkspaceID, user.ID)
+	}
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	var permissions []string
+	for _, scope := range token.Scopes {
+		if data.HasPermission(user.Permissions(), scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+
+	claims := &Claims{
+		Email:         user.Email,
+		UserID:        user.ID,
+		Role:          user.Role,
+		Permissions:   permissions,
+		WorkspaceID:   membership.WorkspaceID,
+		WorkspaceRole: membership.Role,
+	}
+	claims.Subject = strconv.Itoa(user.ID)
+	claims.Audience = accessTokenAudience
+	if token.ExpiresAt != nil {
+		claims.ExpiresAt = token.ExpiresAt.Unix()
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/go-chi/chi/v5"
+)
+
+// userFromURL loads the user named by the {id} URL parameter, writing an error
+// response and returning nil if it can't.
+func (app *Config) userFromURL(w http.ResponseWriter, r *http.Request) *data.User {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"), http.StatusBadRequest)
+		return nil
+	}
+
+	user, err := app.Models.User.GetOne(id)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return nil
+	}
+
+	return user
+}
+
+func (app *Config) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.Models.User.GetAll()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    users,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email     *string `json:"email"`
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Role      *string `json:"role"`
+		Active    *int    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user := app.userFromURL(w, r)
+	if user == nil {
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	if requestPayload.Email != nil {
+		user.Email = *requestPayload.Email
+	}
+	if requestPayload.FirstName != nil {
+		user.FirstName = *requestPayload.FirstName
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = *requestPayload.LastName
+	}
+	if requestPayload.Role != nil {
+		if !data.ValidRole(*requestPayload.Role) {
+			app.errorJSON(w, fmt.Errorf("unknown role %q", *requestPayload.Role), http.StatusBadRequest)
+			return
+		}
+		// stop admins from locking themselves out by accident
+		if user.ID == claims.UserID && *requestPayload.Role != user.Role {
+			app.errorJSON(w, errors.New("you can't change your own role"), http.StatusBadRequest)
+			return
+		}
+		user.Role = *requestPayload.Role
+	}
+	if requestPayload.Active != nil {
+		if user.ID == claims.UserID && *requestPayload.Active != 1 {
+			app.errorJSON(w, errors.New("you can't deactivate yourself"), http.StatusBadRequest)
+			return
+		}
+		user.Active = *requestPayload.Active
+	}
+
+	err = user.Update()
+	if err != nil {
+		app.errorJSON(w, errors.New("unable to update user"), http.StatusBadRequest)
+		return
+	}
+
+	// deactivating here ends sessions the same way DeactivateUser does
+	if user.Active != 1 {
+		err = app.Models.RefreshToken.RevokeAllForUser(user.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = app.Models.AccessToken.RevokeAllForUser(user.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_ = app.logRequest(r.Context(), user.ID, "admin", fmt.Sprintf("%s updated user %d", claims.Email, user.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Updated user %s", user.Email),
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	user := app.userFromURL(w, r)
+	if user == nil {
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+	if user.ID == claims.UserID {
+		app.errorJSON(w, errors.New("you can't deactivate yourself"), http.StatusBadRequest)
+		return
+	}
+
+	user.Active = 0
+	err := user.Update()
+	if err != nil {
+		app.errorJSON(w, errors.New("unable to deactivate user"), http.StatusInternalServerError)
+		return
+	}
+
+	// existing sessions end as soon as their access token expires
+	err = app.Models.RefreshToken.RevokeAllForUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.AccessToken.RevokeAllForUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), user.ID, "admin", fmt.Sprintf("%s deactivated user %d", claims.Email, user.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deactivated user %s", user.Email),
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user := app.userFromURL(w, r)
+	if user == nil {
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+	if user.ID == claims.UserID {
+		app.errorJSON(w, errors.New("you can't delete yourself"), http.StatusBadRequest)
+		return
+	}
+
+	// the user's tasks and logs are removed by the other services afterwards
+	err := app.deleteAccount(user)
+	if err != nil {
+		app.errorJSON(w, errors.New("unable to delete user"), http.StatusInternalServerError)
+		return
+	}
+
+	// filed under the admin, so the record of the deletion outlives the user's logs
+	_ = app.logRequest(r.Context(), claims.UserID, "admin", fmt.Sprintf("%s deleted user %d", claims.Email, user.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Deleted user %s", user.Email),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// UnlockUser clears a lockout caused by failed logins before it expires.
+func (app *Config) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user := app.userFromURL(w, r)
+	if user == nil {
+		return
+	}
+
+	err := user.ResetFailedLogins()
+	if err != nil {
+		app.errorJSON(w, errors.New("unable to unlock user"), http.StatusInternalServerError)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+	_ = app.logRequest(r.Context(), user.ID, "admin", fmt.Sprintf("%s unlocked user %d", claims.Email, user.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Unlocked user %s", user.Email),
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/DaffaJatmiko/authentication-service/event"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// deletionCheckInterval is how often pending deletions are looked at.
+	deletionCheckInterval = time.Minute
+	// maxDeletionRetryDelay caps the wait between publishing a deletion again.
+	maxDeletionRetryDelay = time.Hour
+)
+
+// deletionRetryDelay is how long to wait for services to answer before publishing a
+// deletion again. It doubles with every attempt.
+func deletionRetryDelay(attempts int) time.Duration {
+	delay := time.Duration(math.Pow(2, float64(attempts))) * time.Minute
+	if delay > maxDeletionRetryDelay || delay <= 0 {
+		return maxDeletionRetryDelay
+	}
+	return delay
+}
+
+// deleteAccount deletes a user and starts the deletion workflow that removes their
+// data from the other services.
+func (app *Config) deleteAccount(user *data.User) error {
+	deletion, err := app.Models.Deletion.DeleteUser(user)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleted user %d, waiting for %v", user.ID, deletion.PendingSteps)
+
+	// wake the worker so the event goes out now rather than on the next tick
+	select {
+	case app.DeletionKick <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// runDeletionWorker publishes user.deleted events for pending deletions and records
+// the completions services send back. Deletions that aren't finished are published
+// again, with a growing delay, until every service has answered. It reconnects to
+// RabbitMQ whenever the connection is lost and never returns.
+func (app *Config) runDeletionWorker() {
+	for {
+		conn, err := connectToRabbit(app.Upstreams.RabbitMQ.DialURL())
+		if err != nil {
+			log.Println("Deletion worker can't reach RabbitMQ", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		app.workDeletions(conn)
+		conn.Close()
+	}
+}
+
+func (app *Config) workDeletions(conn *amqp.Connection) {
+	emitter, err := event.NewEventEmitter(conn)
+	if err != nil {
+		log.Println("Error setting up deletion emitter", err)
+		return
+	}
+
+	consumer, err := event.NewConsumer(conn)
+	if err != nil {
+		log.Println("Error setting up deletion consumer", err)
+		return
+	}
+
+	go func() {
+		err := consumer.ListenForCompletions(app.handleDeletionCompleted)
+		if err != nil {
+			log.Println("Error listening for deletion completions", err)
+		}
+	}()
+
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	ticker := time.NewTicker(deletionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		app.publishPendingDeletions(&emitter)
+
+		select {
+		case err := <-closed:
+			log.Println("Lost RabbitMQ connection", err)
+			return
+		case <-ticker.C:
+		case <-app.DeletionKick:
+		}
+	}
+}
+
+func (app *Config) publishPendingDeletions(emitter *event.Emitter) {
+	deletions, err := app.Models.Deletion.GetPending()
+	if err != nil {
+		log.Println("Error loading pending deletions", err)
+		return
+	}
+
+	for _, d := range deletions {
+		if d.LastPublishedAt != nil && time.Since(*d.LastPublishedAt) < deletionRetryDelay(d.Attempts) {
+			continue
+		}
+
+		err := emitter.Publish(context.Background(), event.UserDeleted, event.UserDeletedEvent{
+			DeletionID: d.ID,
+			UserID:     d.UserID,
+			Email:      d.Email,
+		})
+		if err != nil {
+			log.Println("Error publishing user deleted event", err)
+			return
+		}
+
+		if err := app.Models.Deletion.MarkPublished(d.ID); err != nil {
+			log.Println("Error recording deletion attempt", err)
+		}
+
+		if d.Attempts > 0 {
+			log.Printf("Published deletion %d again (attempt %d), still waiting for %v", d.ID, d.Attempts+1, d.PendingSteps)
+		}
+	}
+}
+
+func (app *Config) handleDeletionCompleted(ctx context.Context, e event.DeletionCompletedEvent) error {
+	done, err := app.Models.Deletion.CompleteStep(e.DeletionID, e.Service)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deletion %d: %s finished", e.DeletionID, e.Service)
+
+	if done {
+		_ = app.logRequest(ctx, 0, "admin", fmt.Sprintf("all data for deleted user %d has been removed", e.UserID))
+	}
+
+	return nil
+}
+
+// ListPendingDeletions shows the account deletions still waiting on other services.
+func (app *Config) ListPendingDeletions(w http.ResponseWriter, r *http.Request) {
+	deletions, err := app.Models.Deletion.GetPending()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if deletions == nil {
+		deletions = []*data.AccountDeletion{}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    deletions,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/go-chi/chi/v5"
+)
+
+// RequestExport starts gathering the logged in user's personal data from every
+// service into one archive. It answers straight away; the user polls ExportStatus or
+// waits for the email with the download link.
+func (app *Config) RequestExport(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	if !app.ExportLimiter.Allow(strconv.Itoa(claims.UserID)) {
+		app.errorJSON(w, errors.New("too many exports requested, try again tomorrow"), http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	export, err := app.Models.DataExport.Create(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	go app.buildExport(export, user)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Your export is being prepared. We'll email you a download link when it's ready",
+		Data:    export,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) ExportStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid export id"), http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	export, err := app.Models.DataExport.GetForUser(id, claims.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("export not found"), http.StatusNotFound)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Export is %s", export.Status),
+		Data:    export,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DownloadExport serves a finished archive. The token from the emailed link is the
+// only credential, so the link works when opened straight from a mail client.
+func (app *Config) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	export, err := app.Models.DataExport.GetByToken(chi.URLParam(r, "token"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired download link"), http.StatusNotFound)
+		return
+	}
+
+	filename := fmt.Sprintf("export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(export.Archive)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(export.Archive)
+}
+
+// buildExport collects the user's data, stores the archive and emails the link.
+func (app *Config) buildExport(export *data.DataExport, user *data.User) {
+	archive, err := app.exportArchive(user)
+	if err != nil {
+		log.Printf("Error building export %d: %v", export.ID, err)
+
+		if err := app.Models.DataExport.Fail(export.ID, "some of your data could not be collected, please try again later"); err != nil {
+			log.Println("Error recording failed export", err)
+		}
+		return
+	}
+
+	token, err := app.Models.DataExport.Complete(export.ID, archive)
+	if err != nil {
+		log.Printf("Error storing export %d: %v", export.ID, err)
+		_ = app.Models.DataExport.Fail(export.ID, "your export could not be saved, please try again later")
+		return
+	}
+
+	_ = app.logRequest(context.Background(), user.ID, "authentication", fmt.Sprintf("%s exported their data", user.Email))
+
+	link := app.ExportURL + url.PathEscape(token)
+	message := fmt.Sprintf("Your data export is ready. Download it within %s: %s", data.DataExportTTL, link)
+
+	if err := app.sendMail(user.Email, "Your data export is ready", message); err != nil {
+		log.Println("Error sending export email", err)
+	}
+}
+
+// exportArchive builds a zip with one JSON file per service.
+func (app *Config) exportArchive(user *data.User) ([]byte, error) {
+	memberships, err := app.Models.Workspace.GetForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// task-service only answers for one workspace at a time
+	type workspaceTasks struct {
+		WorkspaceID   int             `json:"workspace_id"`
+		WorkspaceName string          `json:"workspace_name"`
+		Tasks         json.RawMessage `json:"tasks"`
+	}
+
+	tasks := []workspaceTasks{}
+	for _, m := range memberships {
+		found, err := app.fetchServiceData(taskService, "GET", "/tasks/userId",
+			map[string]int{"user_id": user.ID, "workspace_id": m.WorkspaceID})
+		if err != nil {
+			return nil, fmt.Errorf("fetching tasks: %w", err)
+		}
+
+		tasks = append(tasks, workspaceTasks{WorkspaceID: m.WorkspaceID, WorkspaceName: m.WorkspaceName, Tasks: found})
+	}
+
+	logs, err := app.fetchServiceData(loggerService, "POST", "/search", map[string]int{"user_id": user.ID})
+	if err != nil {
+		return nil, fmt.Errorf("fetching logs: %w", err)
+	}
+
+	identities, err := app.Models.Identity.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := app.Models.Session.GetActiveForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]interface{}{
+		"profile.json": map[string]interface{}{
+			"user":       user,
+			"identities": identities,
+			"sessions":   sessions,
+			"workspaces": memberships,
+		},
+		"tasks.json": tasks,
+		"logs.json":  logs,
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, name := range []string{"profile.json", "tasks.json", "logs.json"} {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(files[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fetchServiceData calls path on an upstream service and returns the data field of
+// its response without interpreting it.
+func (app *Config) fetchServiceData(upstream, method, path string, payload interface{}) (json.RawMessage, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(method, app.endpoint(upstream, path), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := app.httpClient(upstream)
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	var jsonFromService struct {
+		Data json.RawMessage `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil {
+		return nil, err
+	}
+
+	if jsonFromService.Data == nil {
+		return json.RawMessage("[]"), nil
+	}
+
+	return jsonFromService.Data, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/DaffaJatmiko/authentication-service/users"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/serviceauth"
+	"github.com/DaffaJatmiko/shared/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const gRpcPort = "50001"
+
+// maxBatchGetUsers caps how many users one BatchGetUsers call can ask for.
+const maxBatchGetUsers = 100
+
+// UserServer lets the other services look users up and check access tokens
+// without going through the broker.
+type UserServer struct {
+	users.UnimplementedUserServiceServer
+	app *Config
+}
+
+func userToPB(u *data.User) *users.User {
+	return &users.User{
+		Id:        int64(u.ID),
+		Email:     u.Email,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Active:    u.Active == 1,
+		Role:      u.Role,
+	}
+}
+
+func (s *UserServer) GetUser(ctx context.Context, req *users.GetUserRequest) (*users.GetUserResponse, error) {
+	user, err := s.app.Models.User.GetOne(int(req.GetId()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &users.GetUserResponse{User: userToPB(user)}, nil
+}
+
+// BatchGetUsers returns the users with the given ids. Ids that don't belong to a
+// user are left out of the response rather than failing the call.
+func (s *UserServer) BatchGetUsers(ctx context.Context, req *users.BatchGetUsersRequest) (*users.BatchGetUsersResponse, error) {
+	if len(req.GetIds()) > maxBatchGetUsers {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d ids can be requested at once", maxBatchGetUsers)
+	}
+
+	res := &users.BatchGetUsersResponse{}
+	if len(req.GetIds()) == 0 {
+		return res, nil
+	}
+
+	ids := make([]int, len(req.GetIds()))
+	for i, id := range req.GetIds() {
+		ids[i] = int(id)
+	}
+
+	found, err := s.app.Models.User.GetMany(ids)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	for _, u := range found {
+		res.Users = append(res.Users, userToPB(u))
+	}
+
+	return res, nil
+}
+
+// ValidateToken checks a JWT access token or a personal access token and returns
+// the claims it carries. It answers with Unauthenticated for any token that
+// shouldn't be accepted.
+func (s *UserServer) ValidateToken(ctx context.Context, req *users.ValidateTokenRequest) (*users.ValidateTokenResponse, error) {
+	var claims *Claims
+	var err error
+
+	if strings.HasPrefix(req.GetToken(), data.AccessTokenPrefix) {
+		claims, err = s.app.introspect(req.GetToken())
+	} else {
+		claims, err = s.app.verifyAccessToken(req.GetToken())
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidToken) || errors.Is(err, errSessionRevoked) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	res := &users.ValidateTokenResponse{
+		UserId:        int64(claims.UserID),
+		Email:         claims.Email,
+		Role:          claims.Role,
+		Permissions:   claims.Permissions,
+		SessionId:     claims.SessionID,
+		WorkspaceId:   int64(claims.WorkspaceID),
+		WorkspaceRole: claims.WorkspaceRole,
+	}
+	if claims.ExpiresAt != 0 {
+		res.ExpiresAt = timestamppb.New(time.Unix(claims.ExpiresAt, 0))
+	}
+
+	return res, nil
+}
+
+// gRPCListen serves UserServer. Unlike the HTTP API, which only the broker may use,
+// task-service may call it too.
+func (app *Config) gRPCListen() {
+	serviceAuth, err := serviceauth.FromEnv("authentication-service", "broker-service", "task-service")
+	if err != nil {
+		log.Fatalf("Failed to set up gRPC service authentication: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRpcPort))
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+
+	s := grpc.NewServer(
+		tracing.ServerOption(),
+		grpc.ChainUnaryInterceptor(requestid.UnaryServerInterceptor(), metrics.UnaryServerInterceptor(), serviceAuth.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(requestid.StreamServerInterceptor(), metrics.StreamServerInterceptor(), serviceAuth.StreamInterceptor()),
+	)
+
+	users.RegisterUserServiceServer(s, &UserServer{app: app})
+
+	log.Printf("gRPC server listening on %s", gRpcPort)
+
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+}
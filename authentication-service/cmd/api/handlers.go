@@ -2,36 +2,63 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/DaffaJatmiko/authentication-service/data"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var jwtKey = []byte("jwt-key")
-
 type Claims struct {
-	Email string `json:"email"`
+	Email       string   `json:"email"`
+	UserID      int      `json:"user_id"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	// SessionID names the login the token belongs to, so services can reject
+	// tokens from revoked sessions before they expire.
+	SessionID string `json:"sid,omitempty"`
+	// WorkspaceID is the workspace the token acts in, and WorkspaceRole the
+	// user's role there. Services scope what the token can see to the workspace.
+	WorkspaceID   int    `json:"workspace_id"`
+	WorkspaceRole string `json:"workspace_role"`
 	jwt.StandardClaims
 }
 
-func (app *Config) GenerateJWT(email string) (string, error) {
-	expirationTime := time.Now().Add(1 * time.Hour)
+// accessTokenTTL is kept short because access tokens can't be revoked; clients use
+// their refresh token to get a new one.
+const accessTokenTTL = 15 * time.Minute
+
+// accessTokenAudience marks a token as an API access token. Other tokens signed with
+// the same keys (email verification links, for example) carry a different audience
+// so they can't be used to call the API.
+const accessTokenAudience = "task-manager"
+
+func (app *Config) GenerateJWT(user *data.User, sessionID string, membership *data.Membership) (string, error) {
+	expirationTime := time.Now().Add(accessTokenTTL)
 
 	claims := &Claims{
-		Email: email,
+		Email:         user.Email,
+		UserID:        user.ID,
+		Role:          user.Role,
+		Permissions:   user.Permissions(),
+		SessionID:     sessionID,
+		WorkspaceID:   membership.WorkspaceID,
+		WorkspaceRole: membership.Role,
 		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  accessTokenAudience,
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := app.Keys.Sign(claims)
 	if err != nil {
 		log.Println(err)
 		return "", err
@@ -54,30 +81,120 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Println("Authenticate users")
-	
+
+	// slow down clients that keep getting passwords wrong, whichever accounts they try
+	ip := app.clientIP(r)
+	if wait := app.LoginGuard.Wait(ip); wait > 0 {
+		app.tooManyAttempts(w, wait, "too many failed login attempts")
+		return
+	}
+
 	// validate the user against the database
 	user, err := app.Models.User.GetByEmail(requestPayload.Email)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials by email") , http.StatusBadRequest)
+		app.LoginGuard.Fail(ip)
+		app.errorJSON(w, errors.New("invalid credentials by email"), http.StatusBadRequest)
 		return
 	}
 
+	if user.Locked() {
+		app.tooManyAttempts(w, time.Until(*user.LockedUntil), "account is temporarily locked")
+		return
+	}
+
+	if user.LastFailedLogin != nil {
+		if wait := retryAfter(user.FailedLogins, *user.LastFailedLogin); wait > 0 {
+			app.tooManyAttempts(w, wait, "too many failed login attempts")
+			return
+		}
+	}
+
 	valid, err := user.PasswordMatches(requestPayload.Password)
 	if err != nil || !valid {
-		log.Println("Checking password of", requestPayload.Password)
+		app.LoginGuard.Fail(ip)
+
+		locked, err := user.RecordFailedLogin()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if locked {
+			log.Printf("Locked user %d after %d failed logins", user.ID, user.FailedLogins)
+			_ = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s locked out after %d failed logins", user.Email, user.FailedLogins))
+
+			go func(user data.User) {
+				if err := app.sendLockoutEmail(&user); err != nil {
+					log.Println("Error sending lockout email", err)
+				}
+			}(*user)
+		}
+
 		app.errorJSON(w, errors.New("invalid credentials by password"), http.StatusBadRequest)
 		return
 	}
 
+	app.LoginGuard.Reset(ip)
+
+	if user.FailedLogins > 0 {
+		err = user.ResetFailedLogins()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !user.EmailVerified {
+		app.errorJSON(w, errors.New("email address has not been verified"), http.StatusForbidden)
+		return
+	}
+
+	if user.Active != 1 {
+		app.errorJSON(w, errors.New("account is inactive"), http.StatusForbidden)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// completeLogin finishes a login for a user whose first factor has been checked.
+// Users with two-factor authentication get a challenge, everybody else gets tokens.
+func (app *Config) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User) {
+	totp, err := app.Models.TOTP.GetByUserID(user.ID)
+	if err != nil && !errors.Is(err, data.ErrTOTPNotEnrolled) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if totp != nil && totp.Enabled {
+		challenge, err := app.generateMFAChallenge(user)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		payload := jsonResponse{
+			Error:   false,
+			Message: "Two-factor authentication required",
+			Data: map[string]any{
+				"mfa_required": true,
+				"mfa_token":    challenge,
+			},
+		}
+
+		app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+
 	// log authentication
-	err = app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email))
+	err = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s logged in", user.Email))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// create a jwt token
-	tokenString, err := app.GenerateJWT(user.Email)
+	// create a jwt token and start a new session
+	tokens, err := app.issueTokens(r, user)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -86,38 +203,339 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    map[string]string{"token": tokenString},
+		Data:    tokens,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// issueTokens starts a new session for user, recording the device and address it
+// came from, and creates its access token and first refresh token.
+func (app *Config) issueTokens(r *http.Request, user *data.User) (map[string]string, error) {
+	membership, err := app.workspaceFor(user, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := app.Models.Session.Create(user.ID, r.UserAgent(), app.clientIP(r), membership.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenString, err := app.GenerateJWT(user, session.ID, membership)
+	if err != nil {
+		return nil, err
+	}
+
+	// the session's refresh tokens form one family
+	refreshToken, err := app.Models.RefreshToken.Issue(user.ID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"token":         tokenString,
+		"refresh_token": refreshToken,
+	}, nil
+}
+
+func (app *Config) Refresh(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	old, newRefreshToken, err := app.Models.RefreshToken.Rotate(requestPayload.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTokenReused):
+			log.Println("Refresh token reuse detected, family revoked")
+			_ = app.logRequest(r.Context(), 0, "authentication", "refresh token reuse detected, sessions revoked")
+			app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		case errors.Is(err, data.ErrTokenNotFound), errors.Is(err, data.ErrTokenExpired):
+			app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		default:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	user, err := app.Models.User.GetOne(old.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	if user.Active != 1 {
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	session, err := app.Models.Session.GetOne(old.FamilyID)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	// the user may have been removed from the workspace since the last refresh
+	membership, err := app.workspaceFor(user, session.WorkspaceID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if membership.WorkspaceID != session.WorkspaceID {
+		err = app.Models.Session.SetWorkspace(session.ID, membership.WorkspaceID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	tokenString, err := app.GenerateJWT(user, old.FamilyID, membership)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.Models.Session.Touch(old.FamilyID, app.clientIP(r))
+	if err != nil {
+		log.Println("Error updating session", err)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Refreshed token for %s", user.Email),
+		Data: map[string]string{
+			"token":         tokenString,
+			"refresh_token": newRefreshToken,
+		},
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) logRequest(name, data string) error {
+func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.Models.RefreshToken.GetByToken(requestPayload.RefreshToken)
+	if err != nil {
+		if errors.Is(err, data.ErrTokenNotFound) {
+			app.errorJSON(w, errors.New("invalid refresh token"), http.StatusUnauthorized)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.RefreshToken.RevokeFamily(token.FamilyID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), token.UserID, "authentication", fmt.Sprintf("user %d logged out", token.UserID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Logged out",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// logRequest writes an entry to the logger service for the request ctx belongs to.
+// userID is the user the entry is about, or 0 if it isn't about anyone; the entry
+// is exported and erased along with that user's data.
+func (app *Config) logRequest(ctx context.Context, userID int, name, data string) error {
 	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
+		Name   string `json:"name"`
+		Data   string `json:"data"`
+		UserID int    `json:"user_id,omitempty"`
 	}
 
 	entry.Name = name
 	entry.Data = data
+	entry.UserID = userID
 
 	jsonData, err := json.MarshalIndent(entry, "", "\t")
-	logServiceUrl := "http://logger-service/log"
+	logServiceUrl := app.endpoint(loggerService, "/log")
+
+	request, err := http.NewRequestWithContext(ctx, "POST", logServiceUrl, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	client := app.httpClient(loggerService)
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	return nil
+}
 
-	request, err := http.NewRequest("POST", logServiceUrl, bytes.NewBuffer(jsonData))
+// sendMail asks mail-service to deliver a message.
+func (app *Config) sendMail(to, subject, message string) error {
+	var msg struct {
+		To      string `json:"to"`
+		Subject string `json:"subject"`
+		Message string `json:"message"`
+	}
+
+	msg.To = to
+	msg.Subject = subject
+	msg.Message = message
+
+	jsonData, err := json.MarshalIndent(msg, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", app.endpoint(mailService, "/send"), bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
+	request.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	_, err = client.Do(request)
+	client := app.httpClient(mailService)
+	response, err := client.Do(request)
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("error calling mail service: status %d", response.StatusCode)
+	}
 
 	return nil
 }
 
+func (app *Config) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// the lookup and the email happen in the background and the response is the same
+	// either way, so callers can't tell whether an address is registered
+	// the request is over by the time the email has gone, but the log entry still
+	// belongs to it
+	ctx := context.WithoutCancel(r.Context())
+	go func(email string) {
+		user, err := app.Models.User.GetByEmail(email)
+		if err != nil {
+			return
+		}
+
+		token, err := app.Models.PasswordReset.Create(user.ID)
+		if err != nil {
+			log.Println("Error creating password reset token", err)
+			return
+		}
+
+		link := app.ResetURL + url.QueryEscape(token)
+		message := fmt.Sprintf("Someone asked to reset the password for your account. "+
+			"Use this link within %s to choose a new one: %s\n\n"+
+			"If it wasn't you, you can ignore this email.", data.PasswordResetTTL, link)
+
+		err = app.sendMail(user.Email, "Reset your password", message)
+		if err != nil {
+			log.Println("Error sending password reset email", err)
+			return
+		}
+
+		_ = app.logRequest(ctx, user.ID, "authentication", fmt.Sprintf("password reset requested for user %d", user.ID))
+	}(requestPayload.Email)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "If that email is registered, a password reset link has been sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.PasswordPolicy.Check(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := app.Models.PasswordReset.Consume(requestPayload.Token)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired reset token"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired reset token"), http.StatusBadRequest)
+		return
+	}
+
+	err = user.ResetPassword(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// sign the user out everywhere, and end any API access, in case the old
+	// password was compromised
+	err = app.Models.RefreshToken.RevokeAllForUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.AccessToken.RevokeAllForUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("password reset for user %d", user.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password has been reset",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
 func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Email     string `json:"email"`
@@ -132,6 +550,12 @@ func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = app.PasswordPolicy.Check(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
 	log.Println("Register user")
 
 	// create a new user
@@ -140,22 +564,28 @@ func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
 		FirstName: requestPayload.FirstName,
 		LastName:  requestPayload.LastName,
 		Password:  requestPayload.Password,
-		Active:    1, // setting user as active by default
+		Active:    1, // Login still refuses the account until the email address is verified
 		CreatedAt: time.Now(),
-    UpdatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	}
 
 	// save the user to the database
-	_, err = app.Models.User.Insert(user)
+	user.ID, err = app.Models.User.Insert(user)
 	if err != nil {
 		app.errorJSON(w, errors.New("unable to create user"), http.StatusBadRequest)
 		return
 	}
 
+	// the user can ask for another link if this one doesn't arrive
+	err = app.sendVerificationEmail(&user)
+	if err != nil {
+		log.Println("Error sending verification email", err)
+	}
+
 	log.Printf("Email: %s, FirstName: %s, LastName: %s, Active: %d\n", user.Email, user.FirstName, user.LastName, user.Active)
 
 	// log registration
-	err = app.logRequest("registration", fmt.Sprintf("%s registered", user.Email))
+	err = app.logRequest(r.Context(), user.ID, "registration", fmt.Sprintf("%s registered", user.Email))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	invitationAudience = "invitation"
+	// defaultInvitationTTL is how long an invitation lasts unless the inviter asks
+	// for something else; maxInvitationTTL caps what they can ask for.
+	defaultInvitationTTL = 7 * 24 * time.Hour
+	maxInvitationTTL     = 30 * 24 * time.Hour
+)
+
+// generateInvitationToken signs the token carried by an invitation link. Its subject
+// is the invitation ID, so revoking the invitation stops the link working.
+func (app *Config) generateInvitationToken(inv *data.Invitation) (string, error) {
+	claims := &EmailClaims{
+		Email: inv.Email,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.Itoa(inv.ID),
+			Audience:  invitationAudience,
+			IssuedAt:  inv.CreatedAt.Unix(),
+			ExpiresAt: inv.ExpiresAt.Unix(),
+		},
+	}
+
+	return app.Keys.Sign(claims)
+}
+
+// invitationFromToken returns the pending invitation a link was made for.
+func (app *Config) invitationFromToken(token string) (*data.Invitation, error) {
+	claims, id, err := app.parseEmailToken(token, invitationAudience)
+	if err != nil {
+		return nil, data.ErrInvitationNotFound
+	}
+
+	inv, err := app.Models.Invitation.GetOne(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.Status != data.InvitationPending || !strings.EqualFold(inv.Email, claims.Email) {
+		return nil, data.ErrInvitationNotFound
+	}
+
+	return inv, nil
+}
+
+// canManageInvitation reports whether the caller may revoke inv. Anyone who can edit
+// users manages every invitation; others manage the invitations they sent and those
+// for workspaces they own or administer.
+func (app *Config) canManageInvitation(claims *Claims, inv *data.Invitation) bool {
+	if data.HasPermission(claims.Permissions, data.PermUsersWrite) {
+		return true
+	}
+	if inv.InvitedBy != nil && *inv.InvitedBy == claims.UserID {
+		return true
+	}
+	if inv.WorkspaceID == nil {
+		return false
+	}
+
+	membership, err := app.Models.Workspace.Membership(*inv.WorkspaceID, claims.UserID)
+	return err == nil && data.CanManageWorkspace(membership.Role)
+}
+
+func (app *Config) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	// admins see everything, others what they sent and what was sent for the
+	// current workspace if they manage it
+	invitedBy, workspaceID := claims.UserID, 0
+	if data.CanManageWorkspace(claims.WorkspaceRole) {
+		workspaceID = claims.WorkspaceID
+	}
+	if data.HasPermission(claims.Permissions, data.PermUsersWrite) {
+		invitedBy, workspaceID = 0, 0
+	}
+
+	invitations, err := app.Models.Invitation.GetAll(invitedBy, workspaceID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    invitations,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateInvitation invites someone by email to join a workspace, by default the
+// caller's current one. Only the workspace's owners and admins, and account admins,
+// can invite people to it. The invitee can also be given an account-wide role, but
+// only one whose permissions the inviter holds themselves, so a manager can't invite
+// an admin.
+func (app *Config) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email         string `json:"email"`
+		Role          string `json:"role"`
+		WorkspaceID   int    `json:"workspace_id"`
+		WorkspaceRole string `json:"workspace_role"`
+		ExpiresInDays int    `json:"expires_in_days"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	email := strings.TrimSpace(requestPayload.Email)
+	if !strings.Contains(email, "@") {
+		app.errorJSON(w, errors.New("invalid email address"), http.StatusBadRequest)
+		return
+	}
+
+	role := requestPayload.Role
+	if role == "" {
+		role = data.RoleUser
+	}
+	if !data.ValidRole(role) {
+		app.errorJSON(w, fmt.Errorf("unknown role %q", role), http.StatusBadRequest)
+		return
+	}
+	if role != data.RoleUser &&
+		(!data.HasPermission(claims.Permissions, data.PermUsersInvite) || !data.RoleIncludes(claims.Permissions, role)) {
+		app.errorJSON(w, fmt.Errorf("you can't invite someone as %s", role), http.StatusForbidden)
+		return
+	}
+
+	workspaceRole := requestPayload.WorkspaceRole
+	if workspaceRole == "" {
+		workspaceRole = data.WorkspaceMember
+	}
+	if !data.ValidWorkspaceRole(workspaceRole) {
+		app.errorJSON(w, fmt.Errorf("unknown workspace role %q", workspaceRole), http.StatusBadRequest)
+		return
+	}
+
+	workspaceID := requestPayload.WorkspaceID
+	if workspaceID == 0 {
+		workspaceID = claims.WorkspaceID
+	}
+
+	workspace, err := app.Models.Workspace.GetOne(workspaceID)
+	if err != nil {
+		app.errorJSON(w, data.ErrWorkspaceNotFound, http.StatusNotFound)
+		return
+	}
+
+	if !data.HasPermission(claims.Permissions, data.PermUsersWrite) {
+		membership, err := app.Models.Workspace.Membership(workspace.ID, claims.UserID)
+		if err != nil || !data.CanManageWorkspace(membership.Role) {
+			app.errorJSON(w, errors.New("only owners and admins can invite people to a workspace"), http.StatusForbidden)
+			return
+		}
+		if workspaceRole == data.WorkspaceOwner && membership.Role != data.WorkspaceOwner {
+			app.errorJSON(w, errors.New("only owners can invite another owner"), http.StatusForbidden)
+			return
+		}
+	}
+
+	ttl := defaultInvitationTTL
+	if requestPayload.ExpiresInDays < 0 {
+		app.errorJSON(w, errors.New("expires_in_days can't be negative"), http.StatusBadRequest)
+		return
+	}
+	if requestPayload.ExpiresInDays > 0 {
+		ttl = time.Duration(requestPayload.ExpiresInDays) * 24 * time.Hour
+		if ttl > maxInvitationTTL {
+			app.errorJSON(w, fmt.Errorf("invitations can't last longer than %d days", int(maxInvitationTTL.Hours()/24)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	inv, err := app.Models.Invitation.Insert(email, role, workspace.ID, workspaceRole, claims.UserID, ttl)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	token, err := app.generateInvitationToken(inv)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	go func() {
+		link := app.InviteURL + url.QueryEscape(token)
+		message := fmt.Sprintf("%s has invited you to join the %s workspace on Task Manager. "+
+			"Open this link before %s to accept: %s",
+			claims.Email, workspace.Name, inv.ExpiresAt.Format(time.RFC1123), link)
+
+		if err := app.sendMail(email, "You've been invited to Task Manager", message); err != nil {
+			log.Println("Error sending invitation", err)
+		}
+	}()
+
+	_ = app.logRequest(r.Context(), claims.UserID, "authentication", fmt.Sprintf("%s invited %s to workspace %d", claims.Email, email, workspace.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Invitation sent to %s", email),
+		Data:    inv,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid invitation id"), http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	inv, err := app.Models.Invitation.GetOne(id)
+	if err != nil || !app.canManageInvitation(claims, inv) {
+		app.errorJSON(w, data.ErrInvitationNotFound, http.StatusNotFound)
+		return
+	}
+
+	err = inv.Revoke()
+	if err != nil {
+		if errors.Is(err, data.ErrInvitationNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), claims.UserID, "authentication", fmt.Sprintf("%s revoked the invitation for %s", claims.Email, inv.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Revoked the invitation for %s", inv.Email),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// InspectInvitation describes the invitation behind a link, so the accept page knows
+// whether to ask for a new password.
+func (app *Config) InspectInvitation(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	inv, err := app.invitationFromToken(requestPayload.Token)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired invitation"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetByEmail(inv.Email)
+	hasAccount := err == nil && user.EmailVerified
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data: map[string]interface{}{
+			"email":             inv.Email,
+			"role":              inv.Role,
+			"workspace_id":      inv.WorkspaceID,
+			"workspace_role":    inv.WorkspaceRole,
+			"expires_at":        inv.ExpiresAt,
+			"password_required": !hasAccount,
+		},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// AcceptInvitation uses an invitation link. Opening the link proves the invitee owns
+// the address, so a new account starts out verified and active. An existing verified
+// account is linked instead: it keeps its password and is given the invited role if
+// that grants more than it has. An unverified account with the address is claimed
+// the same way single sign on claims one, and gets the password sent here. Either
+// way the account joins the invitation's workspace.
+func (app *Config) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token     string `json:"token"`
+		FirstName string `json:"first_name,omitempty"`
+		LastName  string `json:"last_name,omitempty"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	inv, err := app.invitationFromToken(requestPayload.Token)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired invitation"), http.StatusBadRequest)
+		return
+	}
+
+	existing, err := app.Models.User.GetByEmail(inv.Email)
+	if err != nil {
+		existing = nil
+	}
+
+	if existing == nil || !existing.EmailVerified {
+		err = app.PasswordPolicy.Check(requestPayload.Password)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// claim first, so two requests can't both use the invitation
+	err = inv.Claim()
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired invitation"), http.StatusBadRequest)
+		return
+	}
+
+	user, created, err := app.acceptInvitation(inv, existing, requestPayload.FirstName, requestPayload.LastName, requestPayload.Password)
+	if err != nil {
+		if err := inv.Release(); err != nil {
+			log.Println("Error releasing invitation", err)
+		}
+		app.errorJSON(w, errors.New("unable to accept invitation"), http.StatusInternalServerError)
+		return
+	}
+
+	err = inv.SetAcceptedBy(user.ID)
+	if err != nil {
+		log.Println("Error recording invitation acceptance", err)
+	}
+
+	_ = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s accepted an invitation as %s", user.Email, user.Role))
+
+	message := "Invitation accepted, log in with your existing password"
+	if created {
+		message = "Invitation accepted, your account is ready"
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data: map[string]interface{}{
+			"email":           user.Email,
+			"role":            user.Role,
+			"account_created": created,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// acceptInvitation creates or links the account for a claimed invitation and adds it
+// to the invitation's workspace. It reports whether a new account was created.
+func (app *Config) acceptInvitation(inv *data.Invitation, existing *data.User, firstName, lastName, password string) (*data.User, bool, error) {
+	user, created, err := app.accountForInvitation(inv, existing, firstName, lastName, password)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// invitations sent before workspaces existed have none
+	if inv.WorkspaceID != nil {
+		err = app.Models.Workspace.AddMember(*inv.WorkspaceID, user.ID, inv.WorkspaceRole)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return user, created, nil
+}
+
+func (app *Config) accountForInvitation(inv *data.Invitation, existing *data.User, firstName, lastName, password string) (*data.User, bool, error) {
+	if existing == nil {
+		id, err := app.Models.User.Insert(data.User{
+			Email:         inv.Email,
+			FirstName:     firstName,
+			LastName:      lastName,
+			Password:      password,
+			Active:        1,
+			EmailVerified: true,
+			Role:          inv.Role,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+
+		user, err := app.Models.User.GetOne(id)
+		return user, true, err
+	}
+
+	if !existing.EmailVerified {
+		// whoever registered the address never proved they own it
+		if err := app.claimUnverifiedUser(existing); err != nil {
+			return nil, false, err
+		}
+		if err := existing.ResetPassword(password); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if existing.Role != inv.Role && data.RoleIncludes(data.RolePermissions(inv.Role), existing.Role) {
+		existing.Role = inv.Role
+		if err := existing.Update(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return existing, false, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is one private key that tokens can be signed with.
+type signingKey struct {
+	ID         string
+	PrivateKey crypto.Signer
+	Method     jwt.SigningMethod
+}
+
+// KeySet holds the key used to sign new tokens, plus older keys that are still
+// published in the JWKS so tokens they signed keep verifying until they expire.
+type KeySet struct {
+	Current *signingKey
+	Keys    []*signingKey
+}
+
+// loadKeySet reads every PEM encoded PKCS#8 private key (RSA or Ed25519) in dir. The
+// key ID is the file name without extension. The key named by activeKID signs new
+// tokens; when activeKID is empty the last key in lexical order is used, so naming
+// files by date (2024-06-01.pem) rotates keys by dropping in a new file.
+//
+// When dir is empty an ephemeral Ed25519 key is generated. That is fine for local
+// development but tokens won't survive a restart and replicas won't agree on keys.
+func loadKeySet(dir, activeKID string) (*KeySet, error) {
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR not set, generating an ephemeral signing key")
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &signingKey{ID: keyThumbprint(priv.Public()), PrivateKey: priv, Method: jwt.SigningMethodEdDSA}
+		return &KeySet{Current: key, Keys: []*signingKey{key}}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	ks := &KeySet{}
+	for _, f := range files {
+		key, err := readSigningKey(f)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", f, err)
+		}
+
+		ks.Keys = append(ks.Keys, key)
+		if activeKID == "" || key.ID == activeKID {
+			ks.Current = key
+		}
+	}
+
+	if ks.Current == nil {
+		return nil, fmt.Errorf("no signing key found in %s", dir)
+	}
+
+	log.Printf("Loaded %d signing keys, signing with %s", len(ks.Keys), ks.Current.ID)
+	return ks, nil
+}
+
+func readSigningKey(path string) (*signingKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{ID: kid, PrivateKey: k, Method: jwt.SigningMethodRS256}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{ID: kid, PrivateKey: k, Method: jwt.SigningMethodEdDSA}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// keyThumbprint derives a stable key ID from a public key.
+func keyThumbprint(pub crypto.PublicKey) string {
+	der, _ := x509.MarshalPKIXPublicKey(pub)
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+// Sign signs claims with the current key and stamps the key ID into the header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.Current.Method, claims)
+	token.Header["kid"] = ks.Current.ID
+	return token.SignedString(ks.Current.PrivateKey)
+}
+
+// JWK is one JSON Web Key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS returns the public half of every key in the set.
+func (ks *KeySet) JWKS() []JWK {
+	var keys []JWK
+
+	for _, k := range ks.Keys {
+		switch pub := k.PrivateKey.Public().(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "RSA",
+				Kid: k.ID,
+				Use: "sig",
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "OKP",
+				Kid: k.ID,
+				Use: "sig",
+				Alg: "EdDSA",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	return keys
+}
+
+func (app *Config) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	app.writeJSON(w, http.StatusOK, map[string]any{"keys": app.Keys.JWKS()})
+}
+
+// Parse verifies a token signed by any key in the set and decodes it into claims.
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, k := range ks.Keys {
+			if k.ID == kid {
+				if token.Method.Alg() != k.Method.Alg() {
+					return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+				}
+				return k.PrivateKey.Public(), nil
+			}
+		}
+		return nil, errors.New("unknown signing key")
+	})
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid {
+		return errors.New("invalid token")
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestKeySetParse(t *testing.T) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, strangerPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edKey := &signingKey{ID: "2024-01-01", PrivateKey: edPriv, Method: jwt.SigningMethodEdDSA}
+	rsaKey := &signingKey{ID: "2024-06-01", PrivateKey: rsaPriv, Method: jwt.SigningMethodRS256}
+	ks := &KeySet{Current: rsaKey, Keys: []*signingKey{edKey, rsaKey}}
+
+	claims := func(expires time.Time) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(expires)}
+	}
+
+	// sign makes a token with method and key, naming kid in its header
+	sign := func(method jwt.SigningMethod, key any, kid string, c jwt.Claims) string {
+		token := jwt.NewWithClaims(method, c)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	live := claims(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string // part of the error, or empty when the token is good
+	}{
+		{"current key", sign(jwt.SigningMethodRS256, rsaPriv, rsaKey.ID, live), ""},
+		{"older key", sign(jwt.SigningMethodEdDSA, edPriv, edKey.ID, live), ""},
+		{"ed25519 signature under the rsa kid", sign(jwt.SigningMethodEdDSA, edPriv, rsaKey.ID, live), "unexpected signing method EdDSA"},
+		{"rsa signature under the ed25519 kid", sign(jwt.SigningMethodRS256, rsaPriv, edKey.ID, live), "unexpected signing method RS256"},
+		{"hmac keyed with the public key", sign(jwt.SigningMethodHS256, []byte(edPriv.Public().(ed25519.PublicKey)), edKey.ID, live), "unexpected signing method HS256"},
+		{"alg none", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, edKey.ID, live), "unexpected signing method none"},
+		{"unknown kid", sign(jwt.SigningMethodEdDSA, edPriv, "2023-01-01", live), "unknown signing key"},
+		{"no kid", sign(jwt.SigningMethodEdDSA, edPriv, "", live), "unknown signing key"},
+		{"known kid, someone else's key", sign(jwt.SigningMethodEdDSA, strangerPriv, edKey.ID, live), "verification error"},
+		{"expired", sign(jwt.SigningMethodRS256, rsaPriv, rsaKey.ID, claims(time.Now().Add(-time.Minute))), "expired"},
+		{"not a token", "not.a.token", "invalid character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got jwt.RegisteredClaims
+			err := ks.Parse(tt.token, &got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got.Subject != "1" {
+				t.Errorf("Parse decoded subject %q, want %q", got.Subject, "1")
+			}
+		})
+	}
+}
+
+func TestKeySetSignRoundTrip(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := &signingKey{ID: keyThumbprint(priv.Public()), PrivateKey: priv, Method: jwt.SigningMethodEdDSA}
+	ks := &KeySet{Current: key, Keys: []*signingKey{key}}
+
+	token, err := ks.Sign(jwt.RegisteredClaims{Subject: "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got jwt.RegisteredClaims
+	if err := ks.Parse(token, &got); err != nil {
+		t.Fatalf("Parse of a token from Sign: %v", err)
+	}
+	if got.Subject != "42" {
+		t.Errorf("subject = %q, want %q", got.Subject, "42")
+	}
+
+	// a set that has since dropped the key no longer accepts its tokens
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &signingKey{ID: keyThumbprint(other.Public()), PrivateKey: other, Method: jwt.SigningMethodEdDSA}
+	rotated := &KeySet{Current: next, Keys: []*signingKey{next}}
+
+	if err := rotated.Parse(token, &jwt.RegisteredClaims{}); err == nil {
+		t.Error("Parse accepted a token signed by a key no longer in the set")
+	}
+}
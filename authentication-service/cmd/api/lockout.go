@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+)
+
+const (
+	// freeLoginAttempts is how many wrong passwords are allowed before logins
+	// start being slowed down.
+	freeLoginAttempts = 3
+	// maxLoginBackoff caps the delay between attempts.
+	maxLoginBackoff = 5 * time.Minute
+	// loginFailureWindow is how long an address has to stay quiet before its
+	// failures are forgotten.
+	loginFailureWindow = 15 * time.Minute
+)
+
+// loginBackoff returns how long to wait after the given number of consecutive
+// failures. The delay doubles with each failure past freeLoginAttempts.
+func loginBackoff(failures int) time.Duration {
+	if failures < freeLoginAttempts {
+		return 0
+	}
+
+	shift := failures - freeLoginAttempts
+	if shift > 16 {
+		return maxLoginBackoff
+	}
+
+	delay := time.Second << shift
+	if delay > maxLoginBackoff {
+		return maxLoginBackoff
+	}
+	return delay
+}
+
+// retryAfter returns how much longer a caller has to wait after failures wrong
+// passwords, the last of which was at last.
+func retryAfter(failures int, last time.Time) time.Duration {
+	return time.Until(last.Add(loginBackoff(failures)))
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+// loginGuard tracks failed logins per client address in memory, so one client
+// guessing passwords for many accounts is slowed down too.
+type loginGuard struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{failures: make(map[string]*loginFailures)}
+}
+
+// Wait returns how long key has to wait before it may try again.
+func (g *loginGuard) Wait(key string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f, ok := g.failures[key]
+	if !ok {
+		return 0
+	}
+
+	if time.Since(f.last) > loginFailureWindow {
+		delete(g.failures, key)
+		return 0
+	}
+
+	return retryAfter(f.count, f.last)
+}
+
+// Fail records a failed login for key.
+func (g *loginGuard) Fail(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for k, f := range g.failures {
+		if time.Since(f.last) > loginFailureWindow {
+			delete(g.failures, k)
+		}
+	}
+
+	f, ok := g.failures[key]
+	if !ok {
+		f = &loginFailures{}
+		g.failures[key] = f
+	}
+
+	f.count++
+	f.last = time.Now()
+}
+
+// Reset forgets the failures recorded for key.
+func (g *loginGuard) Reset(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.failures, key)
+}
+
+// clientIP returns the address a request came from. Requests arrive through the
+// broker, so when TrustProxyHeaders is set the last X-Forwarded-For entry, the one
+// the broker added, is used. Earlier entries come from the client and can be forged.
+func (app *Config) clientIP(r *http.Request) string {
+	if app.TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			hops := strings.Split(fwd, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// tooManyAttempts writes a 429 response telling the client when it may try again.
+func (app *Config) tooManyAttempts(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := int(wait.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	app.errorJSON(w, fmt.Errorf("%s, try again in %d seconds", message, seconds), http.StatusTooManyRequests)
+}
+
+// sendLockoutEmail tells the owner of an account that it was locked.
+func (app *Config) sendLockoutEmail(user *data.User) error {
+	message := fmt.Sprintf("Your account was locked after %d failed sign in attempts. "+
+		"It will unlock automatically at %s. If this wasn't you, we recommend resetting your password.",
+		user.FailedLogins, user.LockedUntil.UTC().Format(time.RFC1123))
+
+	return app.sendMail(user.Email, "Your account has been locked", message)
+}
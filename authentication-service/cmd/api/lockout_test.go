@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{freeLoginAttempts - 1, 0},
+		{freeLoginAttempts, time.Second},
+		{freeLoginAttempts + 1, 2 * time.Second},
+		{freeLoginAttempts + 8, 256 * time.Second},
+		{freeLoginAttempts + 9, maxLoginBackoff},
+		{freeLoginAttempts + 16, maxLoginBackoff},
+		{freeLoginAttempts + 17, maxLoginBackoff},
+		{1000, maxLoginBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures); got != tt.want {
+			t.Errorf("loginBackoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginGuard(t *testing.T) {
+	g := newLoginGuard()
+
+	for i := 0; i < freeLoginAttempts; i++ {
+		if wait := g.Wait("10.0.0.1"); wait > 0 {
+			t.Fatalf("Wait after %d failures = %s, want none", i, wait)
+		}
+		g.Fail("10.0.0.1")
+	}
+
+	if wait := g.Wait("10.0.0.1"); wait <= 0 || wait > time.Second {
+		t.Errorf("Wait after %d failures = %s, want up to a second", freeLoginAttempts, wait)
+	}
+	if wait := g.Wait("10.0.0.2"); wait > 0 {
+		t.Errorf("Wait for another address = %s, want none", wait)
+	}
+
+	g.Reset("10.0.0.1")
+	if wait := g.Wait("10.0.0.1"); wait > 0 {
+		t.Errorf("Wait after Reset = %s, want none", wait)
+	}
+
+	// failures older than the window are forgotten
+	g.Fail("10.0.0.3")
+	g.failures["10.0.0.3"].count = 100
+	g.failures["10.0.0.3"].last = time.Now().Add(-loginFailureWindow - time.Second)
+	if wait := g.Wait("10.0.0.3"); wait > 0 {
+		t.Errorf("Wait after the window passed = %s, want none", wait)
+	}
+}
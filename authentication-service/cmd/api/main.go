@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/DaffaJatmiko/shared/config"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/outbound"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/serviceauth"
+	"github.com/DaffaJatmiko/shared/tracing"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -18,38 +29,186 @@ import (
 const webPort = "80"
 
 type Config struct {
-	DB *sql.DB
-	Models data.Models
+	DB             *sql.DB
+	Models         data.Models
+	Keys           *KeySet
+	ResetURL       string
+	VerifyURL      string
+	ChangeEmailURL string
+	ExportURL      string
+	InviteURL      string
+	ResendLimiter  *rateLimiter
+	MFALimiter     *rateLimiter
+	ExportLimiter  *rateLimiter
+	LoginGuard     *loginGuard
+	PasswordPolicy *passwordPolicy
+	// TrustProxyHeaders makes clientIP believe X-Forwarded-For. Only enable it
+	// when the service can't be reached except through the broker.
+	TrustProxyHeaders bool
+	// OIDC is nil when single sign on isn't configured.
+	OIDC *oidcClient
+	// DeletionKick wakes the deletion worker when an account is deleted.
+	DeletionKick chan struct{}
+	// ServiceAuth checks that requests come from the broker and signs our calls
+	// to the other internal services.
+	ServiceAuth *serviceauth.Service
+	// Upstreams says where the services we call and RabbitMQ are.
+	Upstreams *config.Config
+	// Outbound makes the HTTP calls to the other services, retrying and backing off
+	// when they fail.
+	Outbound *outbound.Clients
 }
 
 func main() {
 	log.Println("Starting authentication service")
 
+	// find out where the other services are, and stop now if that can't work
+	upstreams, err := config.Load(os.Getenv("CONFIG_FILE"), defaultConfig)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	shutdownTracing, err := tracing.Setup("authentication-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// only the broker may call us; everything else reaches us through it
+	serviceAuth, err := serviceauth.FromEnv("authentication-service", "broker-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	for name, u := range upstreams.Upstreams {
+		if err := serviceAuth.AddUpstream(name, u.URL); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	// calls to the task and logger services carry a service token, the request id
+	// and the trace
+	http.DefaultTransport = tracing.Transport(requestid.Transport(serviceAuth.Transport(http.DefaultTransport)))
+
 	// connect to DB
 	conn := connectToDB()
 	if conn == nil {
 		log.Panic("Can't connect to Postgres!")
 	}
 
+	// the connection pool's stats are served with the other metrics
+	err = metrics.Setup("authentication-service", collectors.NewDBStatsCollector(conn, "users"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// load the keys used to sign tokens
+	keys, err := loadKeySet(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_SIGNING_KID"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// choose how new passwords are hashed; existing hashes are upgraded on login
+	err = configurePasswordHasher(envOrDefault("PASSWORD_HASHER", "argon2id"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	policy, err := newPasswordPolicy(envOrDefault("BREACHED_PASSWORDS_FILE", "./breached-passwords.txt"))
+	if err != nil {
+		log.Panic(err)
+	}
+	log.Printf("Loaded %d breached passwords", policy.Size())
+
 	// set up config
 	app := Config{
-		DB: conn,
-		Models: data.New(conn),
+		DB:             conn,
+		Models:         data.New(conn),
+		Keys:           keys,
+		ResetURL:       envOrDefault("RESET_URL", "http://localhost/reset-password?token="),
+		VerifyURL:      envOrDefault("VERIFY_URL", "http://localhost/verify-email?token="),
+		ChangeEmailURL: envOrDefault("CHANGE_EMAIL_URL", "http://localhost/confirm-email?token="),
+		ExportURL:      envOrDefault("EXPORT_URL", "http://localhost:8080/exports/"),
+		InviteURL:      envOrDefault("INVITE_URL", "http://localhost/accept-invite?token="),
+		// at most three verification emails per address every fifteen minutes
+		ResendLimiter: newRateLimiter(3, 15*time.Minute),
+		// at most five second factor attempts per user every five minutes
+		MFALimiter: newRateLimiter(5, 5*time.Minute),
+		// at most three data exports per user a day
+		ExportLimiter:     newRateLimiter(3, 24*time.Hour),
+		LoginGuard:        newLoginGuard(),
+		PasswordPolicy:    policy,
+		TrustProxyHeaders: os.Getenv("TRUST_PROXY_HEADERS") == "true",
+		OIDC:              newOIDCClient(),
+		DeletionKick:      make(chan struct{}, 1),
+		ServiceAuth:       serviceAuth,
+		Upstreams:         upstreams,
+		Outbound:          outbound.NewClients(upstreams),
 	}
 
+	// tell the other services about deleted accounts and track their cleanup
+	go app.runDeletionWorker()
+
+	// let the broker and task-service look users up over gRPC
+	go app.gRPCListen()
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", webPort),
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if err != nil {
 		log.Panic(err)
 	}
 }
 
+// configurePasswordHasher selects the algorithm used for new password hashes.
+func configurePasswordHasher(name string) error {
+	switch name {
+	case "argon2id":
+		data.SetPasswordHasher(data.DefaultArgon2idHasher())
+	case "bcrypt":
+		data.SetPasswordHasher(data.BcryptHasher{Cost: 12})
+	default:
+		return fmt.Errorf("unknown PASSWORD_HASHER %q", name)
+	}
+	return nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// connectToRabbit dials RabbitMQ, backing off between attempts.
+func connectToRabbit(rabbitURL string) (*amqp.Connection, error) {
+	var counts int64
+	var backoff = 1 * time.Second
+
+	for {
+		c, err := amqp.Dial(rabbitURL)
+		if err == nil {
+			log.Println("Connected to RabbitMQ")
+			return c, nil
+		}
+
+		log.Println("RabbitMQ not yet ready")
+		counts++
+
+		if counts > 5 {
+			return nil, err
+		}
+
+		backoff = time.Duration(math.Pow(float64(counts), 2)) * time.Second
+		log.Printf("backing off %v", backoff)
+		time.Sleep(backoff)
+	}
+}
+
 func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+	db, err := tracing.OpenDB("pgx", dsn, semconv.DBSystemPostgreSQL)
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +220,7 @@ func openDB(dsn string) (*sql.DB, error) {
 
 func connectToDB() *sql.DB {
 	dsn := os.Getenv("DSN")
-	
+
 	for {
 		connection, err := openDB(dsn)
 		counts := 0
@@ -82,4 +241,4 @@ func connectToDB() *sql.DB {
 		time.Sleep(2 * time.Second)
 		continue
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	totpIssuer = "Task Manager"
+	// mfaChallengeAudience marks the short lived token handed out between the
+	// password step and the TOTP step of a login.
+	mfaChallengeAudience = "mfa-challenge"
+	mfaChallengeTTL      = 5 * time.Minute
+)
+
+// generateMFAChallenge signs a token proving that the user got past the password
+// check. It is exchanged, together with a TOTP or recovery code, for real tokens.
+func (app *Config) generateMFAChallenge(user *data.User) (string, error) {
+	claims := &jwt.StandardClaims{
+		Subject:   strconv.Itoa(user.ID),
+		Audience:  mfaChallengeAudience,
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL).Unix(),
+	}
+
+	return app.Keys.Sign(claims)
+}
+
+// AuthenticateTOTP completes a login started by Authenticate for users with
+// two-factor authentication enabled.
+func (app *Config) AuthenticateTOTP(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := &jwt.StandardClaims{}
+	err = app.Keys.Parse(requestPayload.MFAToken, claims)
+	if err != nil || !claims.VerifyAudience(mfaChallengeAudience, true) {
+		app.errorJSON(w, errors.New("invalid or expired login attempt, please log in again"), http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid login attempt"), http.StatusUnauthorized)
+		return
+	}
+
+	// six digits are easy to guess without a limit
+	if !app.MFALimiter.Allow(claims.Subject) {
+		app.errorJSON(w, errors.New("too many attempts, try again later"), http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil || user.Active != 1 {
+		app.errorJSON(w, errors.New("invalid login attempt"), http.StatusUnauthorized)
+		return
+	}
+
+	totp, err := app.Models.TOTP.GetByUserID(user.ID)
+	if err != nil || !totp.Enabled {
+		app.errorJSON(w, errors.New("invalid login attempt"), http.StatusUnauthorized)
+		return
+	}
+
+	var valid bool
+	if requestPayload.RecoveryCode != "" {
+		valid, err = app.Models.RecoveryCode.Consume(user.ID, requestPayload.RecoveryCode)
+	} else {
+		valid, err = totp.Validate(requestPayload.Code)
+	}
+
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		app.errorJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+		return
+	}
+
+	if requestPayload.RecoveryCode != "" {
+		_ = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s used a recovery code", user.Email))
+	}
+
+	err = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s logged in", user.Email))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tokens, err := app.issueTokens(r, user)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Logged in user %s", user.Email),
+		Data:    tokens,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// EnrollTOTP creates a pending TOTP secret for the logged in user. It becomes active
+// once ActivateTOTP sees a valid code generated from it.
+func (app *Config) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	totp, err := app.Models.TOTP.Enroll(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Scan the provisioning URI with your authenticator app, then confirm with a code",
+		Data: map[string]string{
+			"secret":           totp.Secret,
+			"provisioning_uri": totp.ProvisioningURI(totpIssuer, claims.Email),
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ActivateTOTP enables a pending TOTP secret and returns the user's recovery codes.
+// The codes are only ever shown here.
+func (app *Config) ActivateTOTP(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	totp, err := app.Models.TOTP.GetByUserID(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if totp.Enabled {
+		app.errorJSON(w, errors.New("two-factor authentication is already enabled"), http.StatusBadRequest)
+		return
+	}
+
+	valid, err := totp.Validate(requestPayload.Code)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		app.errorJSON(w, errors.New("invalid code"), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := totp.Enable()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), claims.UserID, "authentication", fmt.Sprintf("%s enabled two-factor authentication", claims.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Two-factor authentication enabled. Store these recovery codes somewhere safe",
+		Data:    map[string][]string{"recovery_codes": codes},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// DisableTOTP turns off two-factor authentication. It asks for the password and a
+// current code so a stolen access token alone isn't enough.
+func (app *Config) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	user, err := app.Models.User.GetOne(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	matches, err := user.PasswordMatches(requestPayload.Password)
+	if err != nil || !matches {
+		app.errorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
+
+	totp, err := app.Models.TOTP.GetByUserID(user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	valid, err := totp.Validate(requestPayload.Code)
+	if err != nil || !valid {
+		app.errorJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+		return
+	}
+
+	err = totp.Disable()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Two-factor authentication disabled for user %d", user.ID)
+	_ = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s disabled two-factor authentication", user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Two-factor authentication disabled",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+)
+
+type contextKey string
+
+const claimsKey contextKey = "claims"
+
+var (
+	errInvalidToken   = errors.New("invalid token")
+	errSessionRevoked = errors.New("session has been revoked")
+)
+
+// authenticated rejects requests without a valid access token, or with one from a
+// revoked session, and stores the token's claims in the request context.
+func (app *Config) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if tokenString == "" {
+			app.errorJSON(w, errors.New("authorization token not found"), http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := app.verifyAccessToken(tokenString)
+		if err != nil {
+			if errors.Is(err, errInvalidToken) || errors.Is(err, errSessionRevoked) {
+				app.errorJSON(w, err, http.StatusUnauthorized)
+				return
+			}
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// verifyAccessToken checks a JWT access token and that its session hasn't been
+// revoked, and returns its claims.
+func (app *Config) verifyAccessToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	err := app.Keys.Parse(tokenString, claims)
+	if err != nil || !claims.VerifyAudience(accessTokenAudience, true) {
+		return nil, errInvalidToken
+	}
+
+	if claims.SessionID != "" {
+		revoked, err := app.Models.Session.IsRevoked(claims.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, errSessionRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+// requirePermission only lets through requests whose token grants perm. It must be
+// used after authenticated.
+func (app *Config) requirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(claimsKey).(*Claims)
+			if !ok || !data.HasPermission(claims.Permissions, perm) {
+				app.errorJSON(w, errors.New("forbidden"), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// claimsFromContext returns the claims stored by authenticated.
+func claimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey).(*Claims)
+	return claims
+}
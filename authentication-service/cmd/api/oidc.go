@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcClient signs users in with an external OpenID Connect provider using the
+// authorization code flow with PKCE. The provider's discovery document is fetched
+// on first use, so the service starts even if the provider isn't up yet.
+type oidcClient struct {
+	issuerURL    string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+
+	mu       sync.Mutex
+	config   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// newOIDCClient returns a client for the provider configured in the environment,
+// or nil if single sign on isn't configured.
+func newOIDCClient() *oidcClient {
+	issuerURL := os.Getenv("OIDC_ISSUER_URL")
+	if issuerURL == "" {
+		return nil
+	}
+
+	return &oidcClient{
+		issuerURL:    issuerURL,
+		clientID:     os.Getenv("OIDC_CLIENT_ID"),
+		clientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		redirectURL:  envOrDefault("OIDC_REDIRECT_URL", "http://localhost/oidc/callback"),
+		scopes:       strings.Fields(envOrDefault("OIDC_SCOPES", "openid email profile")),
+	}
+}
+
+// discover fetches the provider's configuration if that hasn't been done yet.
+func (c *oidcClient) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.config != nil {
+		return c.config, c.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, c.issuerURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.config = &oauth2.Config{
+		ClientID:     c.clientID,
+		ClientSecret: c.clientSecret,
+		RedirectURL:  c.redirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       c.scopes,
+	}
+	c.verifier = provider.Verifier(&oidc.Config{ClientID: c.clientID})
+
+	return c.config, c.verifier, nil
+}
+
+// oidcClaims are the ID token claims we use.
+type oidcClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Nonce         string `json:"nonce"`
+}
+
+// StartOIDC begins a single sign on. It returns the provider URL the client should
+// send the user to; the provider sends them back to the redirect URL with a code
+// and state that are then posted to OIDCCallback.
+func (app *Config) StartOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.errorJSON(w, errors.New("single sign on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	config, _, err := app.OIDC.discover(r.Context())
+	if err != nil {
+		log.Println("Error discovering OIDC provider", err)
+		app.errorJSON(w, errors.New("identity provider unavailable"), http.StatusBadGateway)
+		return
+	}
+
+	nonce, _, err := data.GenerateToken()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	state, err := app.Models.OIDCLogin.Create(nonce, verifier)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Redirect to the identity provider",
+		Data: map[string]string{
+			"authorization_url": config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)),
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// OIDCCallback completes a single sign on. The code is exchanged for an ID token,
+// which is verified and mapped to a user: first by a previously linked identity,
+// then by verified email address, and failing that a new account is created.
+func (app *Config) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		app.errorJSON(w, errors.New("single sign on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	var requestPayload struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	login, err := app.Models.OIDCLogin.Consume(requestPayload.State)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired sign in, please try again"), http.StatusBadRequest)
+		return
+	}
+
+	config, verifier, err := app.OIDC.discover(r.Context())
+	if err != nil {
+		log.Println("Error discovering OIDC provider", err)
+		app.errorJSON(w, errors.New("identity provider unavailable"), http.StatusBadGateway)
+		return
+	}
+
+	token, err := config.Exchange(r.Context(), requestPayload.Code, oauth2.VerifierOption(login.CodeVerifier))
+	if err != nil {
+		log.Println("Error exchanging OIDC code", err)
+		app.errorJSON(w, errors.New("sign in with identity provider failed"), http.StatusUnauthorized)
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.errorJSON(w, errors.New("identity provider did not return an id token"), http.StatusUnauthorized)
+		return
+	}
+
+	idToken, err := verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		log.Println("Error verifying OIDC id token", err)
+		app.errorJSON(w, errors.New("sign in with identity provider failed"), http.StatusUnauthorized)
+		return
+	}
+
+	var claims oidcClaims
+	err = idToken.Claims(&claims)
+	if err != nil || claims.Nonce != login.Nonce {
+		app.errorJSON(w, errors.New("sign in with identity provider failed"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.userForIdentity(r.Context(), idToken.Issuer, claims)
+	if err != nil {
+		log.Println("Error linking OIDC identity", err)
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	if user.Active != 1 {
+		app.errorJSON(w, errors.New("account is inactive"), http.StatusForbidden)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// userForIdentity finds or creates the user an external identity belongs to.
+func (app *Config) userForIdentity(ctx context.Context, issuer string, claims oidcClaims) (*data.User, error) {
+	identity, err := app.Models.Identity.GetBySubject(issuer, claims.Subject)
+	if err == nil {
+		return app.Models.User.GetOne(identity.UserID)
+	}
+	if !errors.Is(err, data.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	// only trust the email for linking if the provider vouches for it
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errors.New("identity provider did not supply a verified email address")
+	}
+
+	user, err := app.Models.User.GetByEmail(claims.Email)
+	if err != nil {
+		user, err = app.createUserForIdentity(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+	} else if !user.EmailVerified {
+		// someone registered this address without proving they own it; the
+		// provider has now proven who does, so take the account away from them
+		err = app.claimUnverifiedUser(user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = app.Models.Identity.Insert(data.Identity{
+		UserID:  user.ID,
+		Issuer:  issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_ = app.logRequest(ctx, user.ID, "authentication", fmt.Sprintf("%s linked an identity from %s", user.Email, issuer))
+
+	return user, nil
+}
+
+// createUserForIdentity creates an account just in time for someone signing in with
+// an external provider for the first time. The account gets a random password, so
+// it can only be used through the provider until the user resets it.
+func (app *Config) createUserForIdentity(ctx context.Context, claims oidcClaims) (*data.User, error) {
+	password, _, err := data.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := app.Models.User.Insert(data.User{
+		Email:         claims.Email,
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+		Password:      password,
+		Active:        1,
+		EmailVerified: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_ = app.logRequest(ctx, id, "registration", fmt.Sprintf("%s registered through single sign on", claims.Email))
+
+	return app.Models.User.GetOne(id)
+}
+
+// claimUnverifiedUser resets the password of an unverified account and ends its
+// sessions before it is linked to an identity with the same, verified, address.
+func (app *Config) claimUnverifiedUser(user *data.User) error {
+	password, _, err := data.GenerateToken()
+	if err != nil {
+		return err
+	}
+
+	if err := user.ResetPassword(password); err != nil {
+		return err
+	}
+	if err := app.Models.RefreshToken.RevokeAllForUser(user.ID); err != nil {
+		return err
+	}
+	if err := app.Models.AccessToken.RevokeAllForUser(user.ID); err != nil {
+		return err
+	}
+	if err := user.MarkEmailVerified(); err != nil {
+		return err
+	}
+
+	user.EmailVerified = true
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// minPasswordLength is the shortest password we accept.
+	minPasswordLength = 8
+	// maxPasswordLength stops people sending us megabytes to hash.
+	maxPasswordLength = 128
+)
+
+var errBreachedPassword = errors.New("this password has appeared in a data breach, please choose another")
+
+// passwordPolicy decides whether a new password is strong enough.
+type passwordPolicy struct {
+	MinLength int
+	MaxLength int
+	// breached holds upper case hex SHA-1 hashes of passwords known to have leaked.
+	breached map[string]struct{}
+}
+
+// newPasswordPolicy loads the breached password list from path. Each line is either a
+// password or, as in the Have I Been Pwned downloads, an SHA-1 hash optionally followed
+// by ":count". A missing file leaves only the length rules in place.
+func newPasswordPolicy(path string) (*passwordPolicy, error) {
+	p := &passwordPolicy{
+		MinLength: minPasswordLength,
+		MaxLength: maxPasswordLength,
+		breached:  make(map[string]struct{}),
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return p, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		if !isSHA1Hex(hash) {
+			hash = sha1Hex(line)
+		}
+
+		p.breached[strings.ToUpper(hash)] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Check returns an error describing why password isn't acceptable, or nil.
+func (p *passwordPolicy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if length > p.MaxLength {
+		return fmt.Errorf("password must be at most %d characters", p.MaxLength)
+	}
+
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return errBreachedPassword
+	}
+
+	return nil
+}
+
+// Size returns how many breached passwords the policy knows about.
+func (p *passwordPolicy) Size() int {
+	return len(p.breached)
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
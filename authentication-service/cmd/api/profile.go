@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	changeEmailAudience = "change-email"
+	changeEmailTTL      = 24 * time.Hour
+)
+
+func (app *Config) GetProfile(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	user, err := app.Models.User.GetOne(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// UpdateProfile changes the logged in user's name. Fields left out of the request
+// are unchanged.
+func (app *Config) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	user, err := app.Models.User.GetOne(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	if requestPayload.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*requestPayload.FirstName)
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = strings.TrimSpace(*requestPayload.LastName)
+	}
+
+	err = user.Update()
+	if err != nil {
+		app.errorJSON(w, errors.New("unable to update profile"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Profile updated",
+		Data:    user,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ChangePassword sets a new password for the logged in user. Every other session is
+// signed out; the one making the request stays signed in.
+func (app *Config) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	user, err := app.Models.User.GetOne(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	matches, err := user.PasswordMatches(requestPayload.CurrentPassword)
+	if err != nil || !matches {
+		app.errorJSON(w, errors.New("current password is incorrect"), http.StatusUnauthorized)
+		return
+	}
+
+	err = app.PasswordPolicy.Check(requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = user.ResetPassword(requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.RefreshToken.RevokeOthersForUser(user.ID, claims.SessionID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s changed their password", user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password changed",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ChangeEmail starts moving the logged in user to a new email address. Nothing
+// changes until the link sent to the new address is opened; the old address is
+// told about the request.
+func (app *Config) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Password string `json:"password"`
+		NewEmail string `json:"new_email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	user, err := app.Models.User.GetOne(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	matches, err := user.PasswordMatches(requestPayload.Password)
+	if err != nil || !matches {
+		app.errorJSON(w, errors.New("password is incorrect"), http.StatusUnauthorized)
+		return
+	}
+
+	newEmail := strings.TrimSpace(requestPayload.NewEmail)
+	if !strings.Contains(newEmail, "@") {
+		app.errorJSON(w, errors.New("invalid email address"), http.StatusBadRequest)
+		return
+	}
+
+	if strings.EqualFold(newEmail, user.Email) {
+		app.errorJSON(w, errors.New("that is already your email address"), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.Models.User.GetByEmail(newEmail); err == nil {
+		app.errorJSON(w, errors.New("email address is already in use"), http.StatusConflict)
+		return
+	}
+
+	token, err := app.generateEmailToken(user, newEmail, changeEmailAudience, changeEmailTTL)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	go func(oldEmail string) {
+		link := app.ChangeEmailURL + url.QueryEscape(token)
+		message := fmt.Sprintf("Please confirm your new email address by opening this link within %s: %s",
+			changeEmailTTL, link)
+
+		if err := app.sendMail(newEmail, "Confirm your new email address", message); err != nil {
+			log.Println("Error sending email change confirmation", err)
+		}
+
+		message = fmt.Sprintf("Someone asked to change the email address of your account to %s. "+
+			"If this wasn't you, change your password now.", newEmail)
+
+		if err := app.sendMail(oldEmail, "Your email address is being changed", message); err != nil {
+			log.Println("Error sending email change notice", err)
+		}
+	}(user.Email)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("A confirmation link has been sent to %s", newEmail),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ConfirmEmailChange finishes an email change using the token from the link sent by
+// ChangeEmail. Opening the link proves the user owns the new address, so it stays
+// verified.
+func (app *Config) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims, userID, err := app.parseEmailToken(requestPayload.Token, changeEmailAudience)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired confirmation link"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired confirmation link"), http.StatusBadRequest)
+		return
+	}
+
+	if strings.EqualFold(user.Email, claims.Email) {
+		payload := jsonResponse{
+			Error:   false,
+			Message: fmt.Sprintf("Email changed to %s", user.Email),
+		}
+
+		app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+
+	// the address may have been taken since the link was sent
+	if _, err := app.Models.User.GetByEmail(claims.Email); err == nil {
+		app.errorJSON(w, errors.New("email address is already in use"), http.StatusConflict)
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = claims.Email
+
+	err = user.Update()
+	if err != nil {
+		app.errorJSON(w, errors.New("unable to change email"), http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s changed their email to %s", oldEmail, user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Email changed to %s", user.Email),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
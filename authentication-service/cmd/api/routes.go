@@ -3,6 +3,10 @@ package main
 import (
 	"net/http"
 
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -22,9 +26,99 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(metrics.Endpoint("/metrics"))
+	mux.Use(requestid.Middleware)
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+	mux.Use(app.ServiceAuth.Middleware)
+
+	mux.Get("/.well-known/jwks.json", app.JWKS)
 
 	mux.Post("/authenticate", app.Authenticate)
+	mux.Post("/authenticate/totp", app.AuthenticateTOTP)
 	mux.Post("/register", app.Register)
+	mux.Post("/refresh", app.Refresh)
+	mux.Post("/logout", app.Logout)
+	mux.Post("/password-reset/request", app.RequestPasswordReset)
+	mux.Post("/password-reset/confirm", app.ConfirmPasswordReset)
+	mux.Post("/verify-email", app.VerifyEmail)
+	mux.Post("/verify-email/resend", app.ResendVerification)
+	mux.Post("/tokens/introspect", app.IntrospectAccessToken)
+	mux.Post("/me/email/confirm", app.ConfirmEmailChange)
+	mux.Get("/exports/{token}", app.DownloadExport)
+	mux.Get("/sessions/revoked", app.RevokedSessions)
+	mux.Post("/oidc/start", app.StartOIDC)
+	mux.Post("/oidc/callback", app.OIDCCallback)
+	mux.Post("/invitations/inspect", app.InspectInvitation)
+	mux.Post("/invitations/accept", app.AcceptInvitation)
+
+	mux.Route("/mfa/totp", func(r chi.Router) {
+		r.Use(app.authenticated)
+
+		r.Post("/enroll", app.EnrollTOTP)
+		r.Post("/activate", app.ActivateTOTP)
+		r.Post("/disable", app.DisableTOTP)
+	})
+
+	mux.Route("/me", func(r chi.Router) {
+		r.Use(app.authenticated)
+
+		r.Get("/", app.GetProfile)
+		r.Put("/", app.UpdateProfile)
+		r.Post("/password", app.ChangePassword)
+		r.Post("/email", app.ChangeEmail)
+		r.Post("/export", app.RequestExport)
+		r.Get("/export/{id}", app.ExportStatus)
+	})
+
+	mux.Route("/sessions", func(r chi.Router) {
+		r.Use(app.authenticated)
+
+		r.Get("/", app.ListSessions)
+		r.Delete("/", app.RevokeAllSessions)
+		r.Delete("/{id}", app.RevokeSession)
+	})
+
+	mux.Route("/tokens", func(r chi.Router) {
+		r.Use(app.authenticated)
+
+		r.Get("/", app.ListAccessTokens)
+		r.Post("/", app.CreateAccessToken)
+		r.Delete("/{id}", app.RevokeAccessToken)
+	})
+
+	// who may invite whom, and to which workspace, is checked by the handlers
+	mux.Route("/invitations", func(r chi.Router) {
+		r.Use(app.authenticated)
+
+		r.Get("/", app.ListInvitations)
+		r.Post("/", app.CreateInvitation)
+		r.Delete("/{id}", app.RevokeInvitation)
+	})
+
+	mux.Route("/workspaces", func(r chi.Router) {
+		r.Use(app.authenticated)
+
+		r.Get("/", app.ListWorkspaces)
+		r.Post("/", app.CreateWorkspace)
+		r.Post("/{id}/switch", app.SwitchWorkspace)
+		r.Get("/{id}/members", app.ListWorkspaceMembers)
+		r.Put("/{id}/members/{userId}", app.UpdateWorkspaceMember)
+		r.Delete("/{id}/members/{userId}", app.RemoveWorkspaceMember)
+	})
+
+	mux.Route("/admin/users", func(r chi.Router) {
+		r.Use(app.authenticated)
+
+		r.With(app.requirePermission(data.PermUsersRead)).Get("/", app.ListUsers)
+		r.With(app.requirePermission(data.PermUsersWrite)).Put("/{id}", app.UpdateUser)
+		r.With(app.requirePermission(data.PermUsersWrite)).Post("/{id}/deactivate", app.DeactivateUser)
+		r.With(app.requirePermission(data.PermUsersWrite)).Post("/{id}/unlock", app.UnlockUser)
+		r.With(app.requirePermission(data.PermUsersDelete)).Delete("/{id}", app.DeleteUser)
+	})
+
+	mux.With(app.authenticated, app.requirePermission(data.PermUsersDelete)).
+		Get("/admin/deletions", app.ListPendingDeletions)
 
 	return mux
-}
\ No newline at end of file
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/go-chi/chi/v5"
+)
+
+// ListSessions returns the places the logged in user is signed in. The session the
+// request was made from is flagged as current.
+func (app *Config) ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	sessions, err := app.Models.Session.GetActiveForUser(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	type sessionView struct {
+		*data.Session
+		Current bool `json:"current"`
+	}
+
+	views := []sessionView{}
+	for _, session := range sessions {
+		views = append(views, sessionView{Session: session, Current: session.ID == claims.SessionID})
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    views,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+	id := chi.URLParam(r, "id")
+
+	err := app.Models.Session.Revoke(id, claims.UserID)
+	if err != nil {
+		if errors.Is(err, data.ErrSessionNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), claims.UserID, "authentication", fmt.Sprintf("%s revoked a session", claims.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Session revoked",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// RevokeAllSessions signs the user out everywhere, including the current session.
+func (app *Config) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	err := app.Models.RefreshToken.RevokeAllForUser(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), claims.UserID, "authentication", fmt.Sprintf("%s revoked all sessions", claims.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "All sessions revoked",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// RevokedSessions lists the sessions revoked recently enough that access tokens
+// issued for them may still be unexpired. The broker polls it so it can reject
+// those tokens without a call per request.
+func (app *Config) RevokedSessions(w http.ResponseWriter, r *http.Request) {
+	// a little longer than a token lives, to allow for clock skew
+	since := time.Now().Add(-accessTokenTTL - time.Minute)
+
+	ids, err := app.Models.Session.RevokedSince(since)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    ids,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
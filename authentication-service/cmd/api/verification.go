@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	verifyEmailAudience = "verify-email"
+	verifyEmailTTL      = 24 * time.Hour
+)
+
+// EmailClaims are carried by the signed link sent to confirm an email address. The
+// address is part of the claims, so a link stops working if the user's email changes
+// before it is clicked.
+type EmailClaims struct {
+	Email string `json:"email"`
+	jwt.StandardClaims
+}
+
+// generateEmailToken signs a short lived token proving that whoever holds it
+// received mail at email.
+func (app *Config) generateEmailToken(user *data.User, email, audience string, ttl time.Duration) (string, error) {
+	claims := &EmailClaims{
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  audience,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	}
+
+	return app.Keys.Sign(claims)
+}
+
+// parseEmailToken verifies a token made by generateEmailToken and returns its claims.
+func (app *Config) parseEmailToken(tokenString, audience string) (*EmailClaims, int, error) {
+	claims := &EmailClaims{}
+
+	err := app.Keys.Parse(tokenString, claims)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if !claims.VerifyAudience(audience, true) {
+		return nil, 0, errors.New("invalid token audience")
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return claims, userID, nil
+}
+
+// sendVerificationEmail mails user a link that verifies their email address.
+func (app *Config) sendVerificationEmail(user *data.User) error {
+	token, err := app.generateEmailToken(user, user.Email, verifyEmailAudience, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	link := app.VerifyURL + url.QueryEscape(token)
+	message := fmt.Sprintf("Welcome! Please confirm your email address by opening this link within %s: %s",
+		verifyEmailTTL, link)
+
+	return app.sendMail(user.Email, "Confirm your email address", message)
+}
+
+func (app *Config) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims, userID, err := app.parseEmailToken(requestPayload.Token, verifyEmailAudience)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid or expired verification link"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.Models.User.GetOne(userID)
+	if err != nil || !strings.EqualFold(user.Email, claims.Email) {
+		app.errorJSON(w, errors.New("invalid or expired verification link"), http.StatusBadRequest)
+		return
+	}
+
+	if !user.EmailVerified {
+		err = user.MarkEmailVerified()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		_ = app.logRequest(r.Context(), user.ID, "authentication", fmt.Sprintf("%s verified their email", user.Email))
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Verified %s", user.Email),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(requestPayload.Email)
+	if !app.ResendLimiter.Allow(email) {
+		app.errorJSON(w, errors.New("too many verification emails requested, try again later"), http.StatusTooManyRequests)
+		return
+	}
+
+	// as with password resets, don't reveal whether the address is registered
+	go func() {
+		user, err := app.Models.User.GetByEmail(requestPayload.Email)
+		if err != nil || user.EmailVerified || user.Active != 1 {
+			return
+		}
+
+		err = app.sendVerificationEmail(user)
+		if err != nil {
+			log.Println("Error sending verification email", err)
+		}
+	}()
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "If that email is registered and unverified, a verification link has been sent",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
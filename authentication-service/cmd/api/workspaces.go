@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/DaffaJatmiko/authentication-service/data"
+	"github.com/go-chi/chi/v5"
+)
+
+// personalWorkspaceName names the workspace made for users who don't belong to any.
+const personalWorkspaceName = "Personal"
+
+// workspaceFor picks the workspace a user's tokens are issued for: preferred if they
+// are still a member of it, otherwise the workspace they joined first. Users who
+// belong to no workspace get a personal one.
+func (app *Config) workspaceFor(user *data.User, preferred int) (*data.Membership, error) {
+	memberships, err := app.Models.Workspace.GetForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range memberships {
+		if m.WorkspaceID == preferred {
+			return m, nil
+		}
+	}
+
+	if len(memberships) > 0 {
+		return memberships[0], nil
+	}
+
+	workspace, err := app.Models.Workspace.Create(personalWorkspaceName, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.Models.Workspace.Membership(workspace.ID, user.ID)
+}
+
+// membershipFromURL loads the caller's membership of the workspace named by the {id}
+// URL parameter, writing an error response and returning nil if they aren't a member.
+func (app *Config) membershipFromURL(w http.ResponseWriter, r *http.Request) *data.Membership {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid workspace id"), http.StatusBadRequest)
+		return nil
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	membership, err := app.Models.Workspace.Membership(id, claims.UserID)
+	if err != nil {
+		if errors.Is(err, data.ErrWorkspaceNotFound) {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return nil
+		}
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return nil
+	}
+
+	return membership
+}
+
+// ListWorkspaces returns the workspaces the logged in user belongs to.
+func (app *Config) ListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r.Context())
+
+	memberships, err := app.Models.Workspace.GetForUser(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	type workspace struct {
+		*data.Membership
+		Current bool `json:"current"`
+	}
+
+	var workspaces []workspace
+	for _, m := range memberships {
+		workspaces = append(workspaces, workspace{Membership: m, Current: m.WorkspaceID == claims.WorkspaceID})
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    workspaces,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// CreateWorkspace makes a new workspace owned by the logged in user.
+func (app *Config) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(requestPayload.Name)
+	if name == "" || len(name) > 100 {
+		app.errorJSON(w, errors.New("name must be between 1 and 100 characters"), http.StatusBadRequest)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	workspace, err := app.Models.Workspace.Create(name, claims.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest(r.Context(), claims.UserID, "authentication", fmt.Sprintf("%s created workspace %d", claims.Email, workspace.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Created workspace %s", workspace.Name),
+		Data:    workspace,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// SwitchWorkspace moves the current session to another of the user's workspaces and
+// returns an access token for it. Later refreshes stay in the new workspace.
+func (app *Config) SwitchWorkspace(w http.ResponseWriter, r *http.Request) {
+	membership := app.membershipFromURL(w, r)
+	if membership == nil {
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+
+	user, err := app.Models.User.GetOne(claims.UserID)
+	if err != nil {
+		app.errorJSON(w, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	if claims.SessionID != "" {
+		err = app.Models.Session.SetWorkspace(claims.SessionID, membership.WorkspaceID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tokenString, err := app.GenerateJWT(user, claims.SessionID, membership)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Switched to workspace %s", membership.WorkspaceName),
+		Data: map[string]string{
+			"token": tokenString,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *Config) ListWorkspaceMembers(w http.ResponseWriter, r *http.Request) {
+	membership := app.membershipFromURL(w, r)
+	if membership == nil {
+		return
+	}
+
+	members, err := app.Models.Workspace.Members(membership.WorkspaceID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Success",
+		Data:    members,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// UpdateWorkspaceMember changes a member's role. Owners and admins can change roles,
+// but only owners can make or unmake other owners.
+func (app *Config) UpdateWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Role string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	membership := app.membershipFromURL(w, r)
+	if membership == nil {
+		return
+	}
+
+	if !data.ValidWorkspaceRole(requestPayload.Role) {
+		app.errorJSON(w, fmt.Errorf("unknown workspace role %q", requestPayload.Role), http.StatusBadRequest)
+		return
+	}
+
+	target, ok := app.memberFromURL(w, r, membership)
+	if !ok {
+		return
+	}
+
+	if !data.CanManageWorkspace(membership.Role) {
+		app.errorJSON(w, errors.New("forbidden"), http.StatusForbidden)
+		return
+	}
+
+	if membership.Role != data.WorkspaceOwner &&
+		(target.Role == data.WorkspaceOwner || requestPayload.Role == data.WorkspaceOwner) {
+		app.errorJSON(w, errors.New("only owners can change who owns a workspace"), http.StatusForbidden)
+		return
+	}
+
+	err = app.Models.Workspace.SetMemberRole(target.WorkspaceID, target.UserID, requestPayload.Role)
+	if err != nil {
+		app.membershipError(w, err)
+		return
+	}
+
+	claims := claimsFromContext(r.Context())
+	_ = app.logRequest(r.Context(), target.UserID, "authentication", fmt.Sprintf("%s made user %d %s of workspace %d",
+		claims.Email, target.UserID, requestPayload.Role, target.WorkspaceID))
+
+	target.Role = requestPayload.Role
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%s is now %s", target.Email, target.Role),
+		Data:    target,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// RemoveWorkspaceMember takes someone out of a workspace. Owners and admins can
+// remove others, only owners can remove owners, and anyone can leave.
+func (app *Config) RemoveWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	membership := app.membershipFromURL(w, r)
+	if membership == nil {
+		return
+	}
+
+	target, ok := app.memberFromURL(w, r, membership)
+	if !ok {
+		return
+	}
+
+	leaving := target.UserID == membership.UserID
+
+	if !leaving {
+		if !data.CanManageWorkspace(membership.Role) {
+			app.errorJSON(w, errors.New("forbidden"), http.StatusForbidden)
+			return
+		}
+		if target.Role == data.WorkspaceOwner && membership.Role != data.WorkspaceOwner {
+			app.errorJSON(w, errors.New("only owners can remove an owner"), http.StatusForbidden)
+			return
+		}
+	}
+
+	err := app.Models.Workspace.RemoveMember(target.WorkspaceID, target.UserID)
+	if err != nil {
+		app.membershipError(w, err)
+		return
+	}
+
+	// the member's current access tokens keep working until they expire; their next
+	// refresh moves them to another workspace
+	claims := claimsFromContext(r.Context())
+	_ = app.logRequest(r.Context(), target.UserID, "authentication", fmt.Sprintf("%s removed user %d from workspace %d",
+		claims.Email, target.UserID, target.WorkspaceID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Removed %s from %s", target.Email, target.WorkspaceName),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// memberFromURL loads the membership named by the {userId} URL parameter in the
+// caller's workspace, writing an error response if it can't.
+func (app *Config) memberFromURL(w http.ResponseWriter, r *http.Request, caller *data.Membership) (*data.Membership, bool) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userId"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid user id"), http.StatusBadRequest)
+		return nil, false
+	}
+
+	target, err := app.Models.Workspace.Membership(caller.WorkspaceID, userID)
+	if err != nil {
+		app.membershipError(w, err)
+		return nil, false
+	}
+
+	return target, true
+}
+
+// membershipError writes the response for an error from a membership change.
+func (app *Config) membershipError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, data.ErrWorkspaceNotFound):
+		app.errorJSON(w, errors.New("member not found"), http.StatusNotFound)
+	case errors.Is(err, data.ErrLastOwner):
+		app.errorJSON(w, err, http.StatusBadRequest)
+	default:
+		app.errorJSON(w, err, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// AccessTokenPrefix starts every personal access token, so they can be told apart
+// from JWTs and spotted if they leak into logs or source code.
+const AccessTokenPrefix = "tmpat_"
+
+// ErrTokenRevoked is returned when a presented token has been revoked.
+var ErrTokenRevoked = errors.New("token revoked")
+
+// AccessToken is the structure which holds one personal access token from the
+// database. Access tokens let scripts and bots call the API without an interactive
+// login. Like refresh tokens, only the SHA-256 hash of the token is stored. A token
+// works in the workspace it was created in.
+type AccessToken struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Name        string     `json:"name"`
+	TokenHash   string     `json:"-"`
+	Scopes      []string   `json:"scopes"`
+	WorkspaceID int        `json:"workspace_id"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Insert creates a new access token for userID in a workspace and returns its plain
+// text value. A zero expiresAt means the token never expires.
+func (at *AccessToken) Insert(userID int, name string, scopes []string, workspaceID int, expiresAt time.Time) (*AccessToken, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, _, err := GenerateToken()
+	if err != nil {
+		return nil, "", err
+	}
+	plainText = AccessTokenPrefix + plainText
+
+	token := AccessToken{
+		UserID:      userID,
+		Name:        name,
+		TokenHash:   HashToken(plainText),
+		Scopes:      scopes,
+		WorkspaceID: workspaceID,
+		CreatedAt:   time.Now(),
+	}
+	if !expiresAt.IsZero() {
+		token.ExpiresAt = &expiresAt
+	}
+
+	stmt := `insert into access_tokens (user_id, name, token_hash, scopes, workspace_id, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = db.QueryRowContext(ctx, stmt,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		strings.Join(token.Scopes, ","),
+		token.WorkspaceID,
+		token.ExpiresAt,
+		token.CreatedAt,
+	).Scan(&token.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &token, plainText, nil
+}
+
+const accessTokenColumns = `id, user_id, name, token_hash, scopes, workspace_id, expires_at, last_used_at, revoked_at, created_at`
+
+// scanAccessToken reads one row selected with accessTokenColumns.
+func scanAccessToken(row interface{ Scan(...interface{}) error }) (*AccessToken, error) {
+	var token AccessToken
+	var scopes string
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		&scopes,
+		&token.WorkspaceID,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+
+	return &token, nil
+}
+
+// GetAllForUser returns every access token a user has created, newest first,
+// including expired and revoked ones.
+func (at *AccessToken) GetAllForUser(userID int) ([]*AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + accessTokenColumns + ` from access_tokens where user_id = $1 order by created_at desc`
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*AccessToken
+
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Authenticate looks up a token by its plain text value, checks that it is still
+// usable and records that it was used.
+func (at *AccessToken) Authenticate(plainText string) (*AccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + accessTokenColumns + ` from access_tokens where token_hash = $1`
+
+	token, err := scanAccessToken(db.QueryRowContext(ctx, query, HashToken(plainText)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+
+	if token.RevokedAt != nil {
+		return nil, ErrTokenRevoked
+	}
+
+	if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	now := time.Now()
+	token.LastUsedAt = &now
+
+	_, err = db.ExecContext(ctx, `update access_tokens set last_used_at = $1 where id = $2`, now, token.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Revoke revokes one of userID's access tokens. It returns ErrTokenNotFound if the
+// user has no such token.
+func (at *AccessToken) Revoke(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update access_tokens set revoked_at = coalesce(revoked_at, $1) where id = $2 and user_id = $3`
+
+	res, err := db.ExecContext(ctx, stmt, time.Now(), id, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every access token belonging to a user.
+func (at *AccessToken) RevokeAllForUser(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update access_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID)
+	return err
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// DeletionSteps names the parts of the system that hold data about a user and have
+// to confirm they have removed it before a deletion is complete.
+var DeletionSteps = []string{"tasks", "logs"}
+
+// AccountDeletion is the structure which holds one account deletion from the
+// database. The user row itself is gone by the time the deletion exists, so the
+// user's ID and email are kept here for the other services to act on.
+type AccountDeletion struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	Email           string     `json:"email"`
+	Attempts        int        `json:"attempts"`
+	RequestedAt     time.Time  `json:"requested_at"`
+	LastPublishedAt *time.Time `json:"last_published_at,omitempty"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	PendingSteps    []string   `json:"pending_steps"`
+}
+
+// DeleteUser deletes a user and records an AccountDeletion, with one pending step
+// per entry in DeletionSteps, in the same transaction.
+func (d *AccountDeletion) DeleteUser(user *User) (*AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deletion := AccountDeletion{
+		UserID:       user.ID,
+		Email:        user.Email,
+		RequestedAt:  time.Now(),
+		PendingSteps: DeletionSteps,
+	}
+
+	stmt := `insert into account_deletions (user_id, email, requested_at) values ($1, $2, $3) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, deletion.UserID, deletion.Email, deletion.RequestedAt).Scan(&deletion.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, step := range DeletionSteps {
+		_, err = tx.ExecContext(ctx, `insert into account_deletion_steps (deletion_id, step) values ($1, $2)`,
+			deletion.ID, step)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// access tokens carry their session, so revoking the sessions stops them working
+	// at once; the sessions outlive the user so the revocations are still seen.
+	// Personal access tokens go with the user, which makes them fail validation.
+	_, err = tx.ExecContext(ctx, `update sessions set revoked_at = $1 where user_id = $2 and revoked_at is null`,
+		deletion.RequestedAt, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from users where id = $1`, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &deletion, nil
+}
+
+// MarkPublished records that the deletion event was just sent.
+func (d *AccountDeletion) MarkPublished(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update account_deletions set attempts = attempts + 1, last_published_at = $1 where id = $2`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), id)
+	return err
+}
+
+// CompleteStep records that step has finished for a deletion. Once every step has
+// finished the deletion is marked complete, and done is true.
+func (d *AccountDeletion) CompleteStep(id int, step string) (done bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `update account_deletion_steps set completed_at = $1
+		where deletion_id = $2 and step = $3 and completed_at is null`
+
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), id, step)
+	if err != nil {
+		return false, err
+	}
+
+	var pending int
+	err = tx.QueryRowContext(ctx, `select count(*) from account_deletion_steps where deletion_id = $1 and completed_at is null`,
+		id).Scan(&pending)
+	if err != nil {
+		return false, err
+	}
+
+	if pending == 0 {
+		_, err = tx.ExecContext(ctx, `update account_deletions set completed_at = coalesce(completed_at, $1) where id = $2`,
+			time.Now(), id)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return pending == 0, nil
+}
+
+// GetPending returns the deletions that still have steps left, with those steps.
+func (d *AccountDeletion) GetPending() ([]*AccountDeletion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select d.id, d.user_id, d.email, d.attempts, d.requested_at, d.last_published_at, s.step
+		from account_deletions d
+		join account_deletion_steps s on s.deletion_id = d.id and s.completed_at is null
+		where d.completed_at is null
+		order by d.id`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []*AccountDeletion
+
+	for rows.Next() {
+		var deletion AccountDeletion
+		var step string
+
+		err := rows.Scan(
+			&deletion.ID,
+			&deletion.UserID,
+			&deletion.Email,
+			&deletion.Attempts,
+			&deletion.RequestedAt,
+			&deletion.LastPublishedAt,
+			&step,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// rows for the same deletion are adjacent
+		if n := len(deletions); n > 0 && deletions[n-1].ID == deletion.ID {
+			deletions[n-1].PendingSteps = append(deletions[n-1].PendingSteps, step)
+			continue
+		}
+
+		deletion.PendingSteps = []string{step}
+		deletions = append(deletions, &deletion)
+	}
+
+	return deletions, rows.Err()
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DataExportTTL is how long a finished export can be downloaded.
+const DataExportTTL = 7 * 24 * time.Hour
+
+// The states a DataExport goes through.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport is the structure which holds one export of a user's personal data. The
+// archive is built in the background; once it is ready it can be downloaded with a
+// token that is emailed to the user. Only the token's hash is stored.
+type DataExport struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	TokenHash   string     `json:"-"`
+	Archive     []byte     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// Create records a new, pending, export for userID.
+func (e *DataExport) Create(userID int) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	export := DataExport{
+		UserID:    userID,
+		Status:    ExportPending,
+		CreatedAt: time.Now(),
+	}
+
+	// exports that can no longer be downloaded are just taking up space
+	_, err := db.ExecContext(ctx, `delete from data_exports where expires_at < $1`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `insert into data_exports (user_id, status, created_at) values ($1, $2, $3) returning id`
+
+	err = db.QueryRowContext(ctx, stmt, export.UserID, export.Status, export.CreatedAt).Scan(&export.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// GetForUser returns one of userID's exports, without its archive.
+func (e *DataExport) GetForUser(id, userID int) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, status, error, created_at, completed_at, expires_at
+		from data_exports where id = $1 and user_id = $2`
+
+	var export DataExport
+	err := db.QueryRowContext(ctx, query, id, userID).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.Error,
+		&export.CreatedAt,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// Complete stores the finished archive and returns the plain text download token.
+func (e *DataExport) Complete(id int, archive []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, hash, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `update data_exports set status = $1, archive = $2, token_hash = $3, completed_at = $4, expires_at = $5
+		where id = $6`
+
+	_, err = db.ExecContext(ctx, stmt, ExportReady, archive, hash, time.Now(), time.Now().Add(DataExportTTL), id)
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// Fail marks an export as failed with a reason the user can see.
+func (e *DataExport) Fail(id int, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update data_exports set status = $1, error = $2, completed_at = $3 where id = $4`
+
+	_, err := db.ExecContext(ctx, stmt, ExportFailed, reason, time.Now(), id)
+	return err
+}
+
+// GetByToken returns a ready export, including its archive, by its download token.
+func (e *DataExport) GetByToken(plainText string) (*DataExport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, status, archive, created_at, completed_at, expires_at
+		from data_exports where token_hash = $1 and status = $2`
+
+	var export DataExport
+	err := db.QueryRowContext(ctx, query, HashToken(plainText), ExportReady).Scan(
+		&export.ID,
+		&export.UserID,
+		&export.Status,
+		&export.Archive,
+		&export.CreatedAt,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+
+	if export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	return &export, nil
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+// cheapArgon2id keeps the tests fast; only its parameters matter here.
+func cheapArgon2id() Argon2idHasher {
+	return Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	current := cheapArgon2id()
+
+	hash := func(h PasswordHasher) string {
+		encoded, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	moreMemory := current
+	moreMemory.Memory *= 2
+	moreIterations := current
+	moreIterations.Iterations++
+	shortSalt := current
+	shortSalt.SaltLength = 8
+	longKey := current
+	longKey.KeyLength = 64
+
+	tests := []struct {
+		name       string
+		password   string
+		encoded    string
+		wantMatch  bool
+		wantRehash bool
+		wantErr    error
+	}{
+		{"current parameters", "correct horse", hash(current), true, false, nil},
+		{"wrong password", "wrong horse", hash(current), false, false, nil},
+		{"other memory", "correct horse", hash(moreMemory), true, true, nil},
+		{"other iterations", "correct horse", hash(moreIterations), true, true, nil},
+		{"other salt length", "correct horse", hash(shortSalt), true, true, nil},
+		{"other key length", "correct horse", hash(longKey), true, true, nil},
+		{"bcrypt", "correct horse", hash(BcryptHasher{Cost: 4}), true, true, nil},
+		{"bcrypt, wrong password", "wrong horse", hash(BcryptHasher{Cost: 4}), false, false, nil},
+		{"unknown format", "correct horse", "$md5$abc", false, false, ErrUnknownHashFormat},
+		{"truncated argon2id", "correct horse", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", false, false, ErrUnknownHashFormat},
+	}
+
+	defer SetPasswordHasher(passwordHasher)
+	SetPasswordHasher(current)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := VerifyPassword(tt.password, tt.encoded)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyPassword error = %v, want %v", err, tt.wantErr)
+			}
+
+			if match != tt.wantMatch || rehash != tt.wantRehash {
+				t.Errorf("VerifyPassword = %t, %t; want %t, %t", match, rehash, tt.wantMatch, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordAfterSwitchingToBcrypt(t *testing.T) {
+	defer SetPasswordHasher(passwordHasher)
+
+	old, err := cheapArgon2id().Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetPasswordHasher(BcryptHasher{Cost: 4})
+
+	fresh, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		encoded    string
+		wantRehash bool
+	}{
+		{"hash from the current hasher", fresh, false},
+		{"hash from the previous hasher", old, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := VerifyPassword("correct horse", tt.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !match || rehash != tt.wantRehash {
+				t.Errorf("VerifyPassword = %t, %t; want true, %t", match, rehash, tt.wantRehash)
+			}
+		})
+	}
+}
+
+func TestBcryptNeedsRehash(t *testing.T) {
+	encoded, err := BcryptHasher{Cost: 4}.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cost int
+		want bool
+	}{
+		{4, false},
+		{5, true},
+		{12, true},
+	}
+
+	for _, tt := range tests {
+		if got := (BcryptHasher{Cost: tt.cost}).NeedsRehash(encoded); got != tt.want {
+			t.Errorf("NeedsRehash at cost %d = %t, want %t", tt.cost, got, tt.want)
+		}
+	}
+}
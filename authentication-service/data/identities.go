@@ -0,0 +1,180 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OIDCLoginTTL is how long a user has to finish signing in with the identity
+// provider after starting.
+const OIDCLoginTTL = 10 * time.Minute
+
+// ErrIdentityNotFound is returned when no user is linked to an external identity.
+var ErrIdentityNotFound = errors.New("identity not found")
+
+// Identity is the structure which holds one link between a user and an account at
+// an external OpenID Connect provider. The provider's issuer and subject together
+// identify the external account; the email is kept for reference only.
+type Identity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetBySubject returns the identity with the given issuer and subject, or
+// ErrIdentityNotFound.
+func (i *Identity) GetBySubject(issuer, subject string) (*Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, issuer, subject, email, created_at from user_identities
+		where issuer = $1 and subject = $2`
+
+	var identity Identity
+	err := db.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Issuer,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// GetAllForUser returns every external identity linked to a user.
+func (i *Identity) GetAllForUser(userID int) ([]*Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, issuer, subject, email, created_at from user_identities
+		where user_id = $1 order by created_at`
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+
+	for rows.Next() {
+		var identity Identity
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Issuer,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		identities = append(identities, &identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// Insert links an external identity to a user and returns the new row's ID.
+func (i *Identity) Insert(identity Identity) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into user_identities (user_id, issuer, subject, email, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err := db.QueryRowContext(ctx, stmt,
+		identity.UserID,
+		identity.Issuer,
+		identity.Subject,
+		identity.Email,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// OIDCLogin is the structure which holds the state of one sign in with an external
+// provider between sending the user away and them coming back. It is looked up by
+// the hash of the state parameter and can only be used once.
+type OIDCLogin struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// Create stores a new login and returns the plain text state to send to the provider.
+func (l *OIDCLogin) Create(nonce, codeVerifier string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	state, hash, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	// opportunistically clear out logins nobody finished
+	_, err = db.ExecContext(ctx, `delete from oidc_logins where expires_at < $1`, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `insert into oidc_logins (state_hash, nonce, code_verifier, expires_at) values ($1, $2, $3, $4)`
+
+	_, err = db.ExecContext(ctx, stmt, hash, nonce, codeVerifier, time.Now().Add(OIDCLoginTTL))
+	if err != nil {
+		return "", err
+	}
+
+	return state, nil
+}
+
+// Consume returns and deletes the login for state. It returns ErrTokenNotFound if the
+// state is unknown or was already used, and ErrTokenExpired if it is too old.
+func (l *OIDCLogin) Consume(state string) (*OIDCLogin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from oidc_logins where state_hash = $1
+		returning state_hash, nonce, code_verifier, expires_at`
+
+	var login OIDCLogin
+	err := db.QueryRowContext(ctx, stmt, HashToken(state)).Scan(
+		&login.StateHash,
+		&login.Nonce,
+		&login.CodeVerifier,
+		&login.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+
+	if time.Now().After(login.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	return &login, nil
+}
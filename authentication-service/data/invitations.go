@@ -0,0 +1,234 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The states an invitation can be in.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+// ErrInvitationNotFound is returned when an invitation doesn't exist or can no
+// longer be accepted or revoked.
+var ErrInvitationNotFound = errors.New("invitation not found or no longer valid")
+
+// Invitation is the structure which holds one invitation to join a workspace.
+// The link sent to the invitee is signed rather than stored, so the row only
+// records who was invited, with which roles, and what became of it. Role is the
+// account-wide role and WorkspaceRole the role within the workspace.
+type Invitation struct {
+	ID            int        `json:"id"`
+	Email         string     `json:"email"`
+	Role          string     `json:"role"`
+	WorkspaceID   *int       `json:"workspace_id"`
+	WorkspaceRole string     `json:"workspace_role"`
+	InvitedBy     *int       `json:"invited_by"`
+	AcceptedBy    *int       `json:"accepted_by,omitempty"`
+	Status        string     `json:"status"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	AcceptedAt    *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// Insert creates an invitation for email to join workspaceID. Pending invitations
+// already sent to the same address for the same workspace are revoked, so only the
+// newest link works.
+func (i *Invitation) Insert(email, role string, workspaceID int, workspaceRole string, invitedBy int, ttl time.Duration) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	_, err = tx.ExecContext(ctx, `update invitations set revoked_at = $1
+		where lower(email) = lower($2) and workspace_id = $3 and accepted_at is null and revoked_at is null`,
+		now, email, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := Invitation{
+		Email:         email,
+		Role:          role,
+		WorkspaceID:   &workspaceID,
+		WorkspaceRole: workspaceRole,
+		InvitedBy:     &invitedBy,
+		Status:        InvitationPending,
+		ExpiresAt:     now.Add(ttl),
+		CreatedAt:     now,
+	}
+
+	stmt := `insert into invitations (email, role, workspace_id, workspace_role, invited_by, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, inv.Email, inv.Role, workspaceID, workspaceRole, invitedBy, inv.ExpiresAt, inv.CreatedAt).Scan(&inv.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &inv, nil
+}
+
+const invitationColumns = `id, email, role, workspace_id, workspace_role, invited_by, accepted_by, expires_at, accepted_at, revoked_at, created_at`
+
+// scanInvitation reads one row selected with invitationColumns.
+func scanInvitation(row interface{ Scan(...interface{}) error }) (*Invitation, error) {
+	var inv Invitation
+
+	err := row.Scan(
+		&inv.ID,
+		&inv.Email,
+		&inv.Role,
+		&inv.WorkspaceID,
+		&inv.WorkspaceRole,
+		&inv.InvitedBy,
+		&inv.AcceptedBy,
+		&inv.ExpiresAt,
+		&inv.AcceptedAt,
+		&inv.RevokedAt,
+		&inv.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case inv.AcceptedAt != nil:
+		inv.Status = InvitationAccepted
+	case inv.RevokedAt != nil:
+		inv.Status = InvitationRevoked
+	case time.Now().After(inv.ExpiresAt):
+		inv.Status = InvitationExpired
+	default:
+		inv.Status = InvitationPending
+	}
+
+	return &inv, nil
+}
+
+// GetOne returns one invitation by id.
+func (i *Invitation) GetOne(id int) (*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	row := db.QueryRowContext(ctx, `select `+invitationColumns+` from invitations where id = $1`, id)
+
+	inv, err := scanInvitation(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvitationNotFound
+		}
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// GetAll returns invitations, newest first. A non-zero invitedBy limits the list to
+// the invitations that user sent, and a non-zero workspaceID to those for that
+// workspace. When both are given, invitations matching either are returned.
+func (i *Invitation) GetAll(invitedBy, workspaceID int) ([]*Invitation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + invitationColumns + ` from invitations
+		where ($1 = 0 and $2 = 0) or invited_by = $1 or workspace_id = $2
+		order by created_at desc`
+
+	rows, err := db.QueryContext(ctx, query, invitedBy, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+
+	return invitations, rows.Err()
+}
+
+// Revoke stops a pending invitation from being accepted.
+func (i *Invitation) Revoke() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `update invitations set revoked_at = $1
+		where id = $2 and accepted_at is null and revoked_at is null`,
+		time.Now(), i.ID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+// Claim marks a pending invitation as accepted. Checking and marking happen in one
+// statement so an invitation can only be used once. Release undoes a claim when the
+// account it was for couldn't be set up.
+func (i *Invitation) Claim() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `update invitations set accepted_at = $1
+		where id = $2 and accepted_at is null and revoked_at is null and expires_at > $1`,
+		time.Now(), i.ID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+// Release makes a claimed invitation pending again.
+func (i *Invitation) Release() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update invitations set accepted_at = null where id = $1 and accepted_by is null`, i.ID)
+	return err
+}
+
+// SetAcceptedBy records which account a claimed invitation was used for.
+func (i *Invitation) SetAcceptedBy(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update invitations set accepted_by = $1 where id = $2`, userID, i.ID)
+	if err != nil {
+		return err
+	}
+
+	i.AcceptedBy = &userID
+	return nil
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// MaxFailedLogins is how many wrong passwords in a row lock an account.
+	MaxFailedLogins = 10
+	// LockoutDuration is how long a locked account stays locked.
+	LockoutDuration = 30 * time.Minute
+)
+
+// Locked reports whether the account is currently locked out.
+func (u *User) Locked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
+
+// RecordFailedLogin counts a wrong password against the account and locks it once
+// MaxFailedLogins is reached. It reports whether this failure locked the account.
+// The counter isn't reset when a lock expires, so after a lockout every further
+// wrong password locks the account again straight away.
+func (u *User) RecordFailedLogin() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	stmt := `update users set
+		failed_logins = failed_logins + 1,
+		last_failed_login_at = $1,
+		locked_until = case when failed_logins + 1 >= $2 then $3 else locked_until end
+		where id = $4
+		returning failed_logins, locked_until`
+
+	err := db.QueryRowContext(ctx, stmt, now, MaxFailedLogins, now.Add(LockoutDuration), u.ID).
+		Scan(&u.FailedLogins, &u.LockedUntil)
+	if err != nil {
+		return false, err
+	}
+
+	u.LastFailedLogin = &now
+
+	return u.FailedLogins >= MaxFailedLogins, nil
+}
+
+// ResetFailedLogins clears the failed login counter and any lock. It is called after
+// a successful login and when an admin unlocks an account.
+func (u *User) ResetFailedLogins() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set failed_logins = 0, last_failed_login_at = null, locked_until = null where id = $1`
+
+	_, err := db.ExecContext(ctx, stmt, u.ID)
+	if err != nil {
+		return err
+	}
+
+	u.FailedLogins = 0
+	u.LastFailedLogin = nil
+	u.LockedUntil = nil
+
+	return nil
+}
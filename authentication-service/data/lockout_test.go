@@ -0,0 +1,30 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserLocked(t *testing.T) {
+	at := func(d time.Duration) *time.Time {
+		t := time.Now().Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name        string
+		lockedUntil *time.Time
+		want        bool
+	}{
+		{"never locked", nil, false},
+		{"lock expired", at(-time.Second), false},
+		{"locked", at(LockoutDuration), true},
+	}
+
+	for _, tt := range tests {
+		u := &User{LockedUntil: tt.lockedUntil}
+		if got := u.Locked(); got != tt.want {
+			t.Errorf("%s: Locked = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -3,11 +3,8 @@ package data
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"log"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 const dbTimeout = time.Second * 3
@@ -20,7 +17,19 @@ func New(dbPool *sql.DB) Models {
 	db = dbPool
 
 	return Models{
-		User: User{},
+		User:          User{},
+		RefreshToken:  RefreshToken{},
+		PasswordReset: PasswordReset{},
+		TOTP:          TOTP{},
+		RecoveryCode:  RecoveryCode{},
+		AccessToken:   AccessToken{},
+		Identity:      Identity{},
+		OIDCLogin:     OIDCLogin{},
+		Session:       Session{},
+		Deletion:      AccountDeletion{},
+		DataExport:    DataExport{},
+		Invitation:    Invitation{},
+		Workspace:     Workspace{},
 	}
 }
 
@@ -28,19 +37,36 @@ func New(dbPool *sql.DB) Models {
 // in this type is available to us throughout the application, anywhere that the
 // app variable is used, provided that the model is also added in the New function.
 type Models struct {
-	User User
+	User          User
+	RefreshToken  RefreshToken
+	PasswordReset PasswordReset
+	TOTP          TOTP
+	RecoveryCode  RecoveryCode
+	AccessToken   AccessToken
+	Identity      Identity
+	OIDCLogin     OIDCLogin
+	Session       Session
+	Deletion      AccountDeletion
+	DataExport    DataExport
+	Invitation    Invitation
+	Workspace     Workspace
 }
 
 // User is the structure which holds one user from the database.
 type User struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name,omitempty"`
-	LastName  string    `json:"last_name,omitempty"`
-	Password  string    `json:"-"`
-	Active    int       `json:"active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	FirstName       string     `json:"first_name,omitempty"`
+	LastName        string     `json:"last_name,omitempty"`
+	Password        string     `json:"-"`
+	Active          int        `json:"active"`
+	EmailVerified   bool       `json:"email_verified"`
+	Role            string     `json:"role"`
+	FailedLogins    int        `json:"failed_logins"`
+	LastFailedLogin *time.Time `json:"-"`
+	LockedUntil     *time.Time `json:"locked_until,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 // GetAll returns a slice of all users, sorted by last name
@@ -48,7 +74,7 @@ func (u *User) GetAll() ([]*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at
+	query := `select id, email, first_name, last_name, password, user_active, email_verified, role, failed_logins, last_failed_login_at, locked_until, created_at, updated_at
 	from users order by last_name`
 
 	rows, err := db.QueryContext(ctx, query)
@@ -68,6 +94,11 @@ func (u *User) GetAll() ([]*User, error) {
 			&user.LastName,
 			&user.Password,
 			&user.Active,
+			&user.EmailVerified,
+			&user.Role,
+			&user.FailedLogins,
+			&user.LastFailedLogin,
+			&user.LockedUntil,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -87,7 +118,7 @@ func (u *User) GetByEmail(email string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, user_active, email_verified, role, failed_logins, last_failed_login_at, locked_until, created_at, updated_at from users where email = $1`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, email)
@@ -99,6 +130,11 @@ func (u *User) GetByEmail(email string) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.EmailVerified,
+		&user.Role,
+		&user.FailedLogins,
+		&user.LastFailedLogin,
+		&user.LockedUntil,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -115,7 +151,7 @@ func (u *User) GetOne(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, user_active, email_verified, role, failed_logins, last_failed_login_at, locked_until, created_at, updated_at from users where id = $1`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, id)
@@ -127,6 +163,11 @@ func (u *User) GetOne(id int) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.EmailVerified,
+		&user.Role,
+		&user.FailedLogins,
+		&user.LastFailedLogin,
+		&user.LockedUntil,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -138,6 +179,50 @@ func (u *User) GetOne(id int) (*User, error) {
 	return &user, nil
 }
 
+// GetMany returns the users with the given ids, sorted by id. Ids that don't
+// belong to a user are skipped.
+func (u *User) GetMany(ids []int) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, password, user_active, email_verified, role, failed_logins, last_failed_login_at, locked_until, created_at, updated_at
+	from users where id = any($1) order by id`
+
+	rows, err := db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Password,
+			&user.Active,
+			&user.EmailVerified,
+			&user.Role,
+			&user.FailedLogins,
+			&user.LastFailedLogin,
+			&user.LockedUntil,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
 // Update updates one user in the database, using the information
 // stored in the receiver u
 func (u *User) Update() error {
@@ -149,8 +234,9 @@ func (u *User) Update() error {
 		first_name = $2,
 		last_name = $3,
 		user_active = $4,
-		updated_at = $5
-		where id = $6
+		role = $5,
+		updated_at = $6
+		where id = $7
 	`
 
 	_, err := db.ExecContext(ctx, stmt,
@@ -158,6 +244,7 @@ func (u *User) Update() error {
 		u.FirstName,
 		u.LastName,
 		u.Active,
+		u.Role,
 		time.Now(),
 		u.ID,
 	)
@@ -204,14 +291,18 @@ func (u *User) Insert(user User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	hashedPassword, err := HashPassword(user.Password)
 	if err != nil {
 		return 0, err
 	}
 
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, user_active, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, user_active, email_verified, role, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
 
 	err = db.QueryRowContext(ctx, stmt,
 		user.Email,
@@ -219,6 +310,8 @@ func (u *User) Insert(user User) (int, error) {
 		user.LastName,
 		hashedPassword,
 		user.Active,
+		user.EmailVerified,
+		user.Role,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -230,12 +323,27 @@ func (u *User) Insert(user User) (int, error) {
 	return newID, nil
 }
 
+// MarkEmailVerified flags the user's email address as verified. It leaves the
+// account's active flag alone, so verifying can't undo a deactivation.
+func (u *User) MarkEmailVerified() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set email_verified = true, updated_at = $1 where id = $2`
+	_, err := db.ExecContext(ctx, stmt, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // ResetPassword is the method we will use to change a user's password.
 func (u *User) ResetPassword(password string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := HashPassword(password)
 	if err != nil {
 		return err
 	}
@@ -249,21 +357,43 @@ func (u *User) ResetPassword(password string) error {
 	return nil
 }
 
-// PasswordMatches uses Go's bcrypt package to compare a user supplied password
-// with the hash we have stored for a given user in the database. If the password
-// and hash match, we return true; otherwise, we return false.
+// PasswordMatches compares a user supplied password with the hash we have stored
+// for a given user in the database. If the password and hash match, we return true;
+// otherwise, we return false. A matching password whose hash was made with an older
+// algorithm or weaker parameters is rehashed with the current ones.
 func (u *User) PasswordMatches(plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(plainText))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			// invalid password
-			return false, nil
-		default:
-			return false, err
+	matches, rehash, err := VerifyPassword(plainText, u.Password)
+	if err != nil || !matches {
+		return false, err
+	}
+
+	if rehash {
+		// the login still succeeds if this fails; we'll try again next time
+		if err := u.rehashPassword(plainText); err != nil {
+			log.Printf("Error rehashing password for user %d: %v", u.ID, err)
 		}
 	}
-	
 
 	return true, nil
 }
+
+// rehashPassword replaces the stored hash, unless the password was changed
+// since the user was loaded.
+func (u *User) rehashPassword(plainText string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := HashPassword(plainText)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update users set password = $1 where id = $2 and password = $3`
+	_, err = db.ExecContext(ctx, stmt, hashedPassword, u.ID, u.Password)
+	if err != nil {
+		return err
+	}
+
+	u.Password = hashedPassword
+	return nil
+}
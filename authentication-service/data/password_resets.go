@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// PasswordResetTTL is how long a password reset token can be used after it is issued.
+const PasswordResetTTL = time.Hour
+
+// PasswordReset is the structure which holds one password reset token from the
+// database. As with refresh tokens only the hash is stored.
+type PasswordReset struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	TokenHash string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Create issues a new reset token for userID and returns its plain text value. Any
+// reset token issued to the user earlier stops working.
+func (p *PasswordReset) Create(userID int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, hash, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.ExecContext(ctx, `update password_resets set used_at = $1 where user_id = $2 and used_at is null`,
+		time.Now(), userID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `insert into password_resets (user_id, token_hash, expires_at, created_at)
+		values ($1, $2, $3, $4)`
+
+	_, err = db.ExecContext(ctx, stmt, userID, hash, time.Now().Add(PasswordResetTTL), time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// Consume marks a reset token as used and returns the ID of the user it belongs to.
+// The token must exist, be unused and not have expired; otherwise ErrTokenNotFound
+// is returned. Marking and checking happen in one statement so a token can only be
+// used once.
+func (p *PasswordReset) Consume(plainText string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update password_resets set used_at = $1
+		where token_hash = $2 and used_at is null and expires_at > $1
+		returning user_id`
+
+	var userID int
+	err := db.QueryRowContext(ctx, stmt, time.Now(), HashToken(plainText)).Scan(&userID)
+	if err != nil {
+		return 0, ErrTokenNotFound
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// RefreshTokenTTL is how long a refresh token stays usable after it is issued.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	// ErrTokenNotFound is returned when a presented token does not exist.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrTokenExpired is returned when a presented token is past its expiry.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenReused is returned when an already rotated or revoked refresh token is
+	// presented again. The whole token family is revoked when this happens.
+	ErrTokenReused = errors.New("refresh token reuse detected")
+)
+
+// RefreshToken is the structure which holds one refresh token from the database. Only
+// the SHA-256 hash of the token is stored; the plain text value is handed to the
+// client once and never persisted.
+type RefreshToken struct {
+	ID        int          `json:"id"`
+	UserID    int          `json:"user_id"`
+	TokenHash string       `json:"-"`
+	FamilyID  string       `json:"family_id"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	RevokedAt sql.NullTime `json:"-"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// GenerateToken returns a random, URL safe token and its SHA-256 hash.
+func GenerateToken() (plainText string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	plainText = base64.RawURLEncoding.EncodeToString(b)
+	return plainText, HashToken(plainText), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a plain text token.
+func HashToken(plainText string) string {
+	sum := sha256.Sum256([]byte(plainText))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue creates a new refresh token for userID in the given family and returns its
+// plain text value. An empty familyID starts a new family.
+func (rt *RefreshToken) Issue(userID int, familyID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, hash, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	if familyID == "" {
+		familyID, _, err = GenerateToken()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	stmt := `insert into refresh_tokens (user_id, token_hash, family_id, expires_at, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err = db.ExecContext(ctx, stmt, userID, hash, familyID, time.Now().Add(RefreshTokenTTL), time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// GetByToken returns one refresh token by its plain text value
+func (rt *RefreshToken) GetByToken(plainText string) (*RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, token_hash, family_id, expires_at, revoked_at, created_at
+		from refresh_tokens where token_hash = $1`
+
+	var token RefreshToken
+	row := db.QueryRowContext(ctx, query, HashToken(plainText))
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.ExpiresAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Rotate exchanges a valid refresh token for a new one in the same family. The old
+// token is revoked. Presenting a token that was already revoked is treated as theft:
+// the whole family is revoked and ErrTokenReused is returned.
+func (rt *RefreshToken) Rotate(plainText string) (*RefreshToken, string, error) {
+	token, err := rt.GetByToken(plainText)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := token.usable(time.Now()); err != nil {
+		if errors.Is(err, ErrTokenReused) {
+			if err := rt.RevokeFamily(token.FamilyID); err != nil {
+				return nil, "", err
+			}
+		}
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// revoke only if nobody else got there first, so two concurrent refreshes with
+	// the same token can't both succeed
+	res, err := db.ExecContext(ctx, `update refresh_tokens set revoked_at = $1 where id = $2 and revoked_at is null`,
+		time.Now(), token.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		if err := rt.RevokeFamily(token.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrTokenReused
+	}
+
+	newToken, err := rt.Issue(token.UserID, token.FamilyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return token, newToken, nil
+}
+
+// usable reports why the token can't be exchanged at now: ErrTokenReused if it was
+// already revoked, which is checked first so a stolen token is caught even after it
+// expires, or ErrTokenExpired.
+func (rt *RefreshToken) usable(now time.Time) error {
+	if rt.RevokedAt.Valid {
+		return ErrTokenReused
+	}
+
+	if now.After(rt.ExpiresAt) {
+		return ErrTokenExpired
+	}
+
+	return nil
+}
+
+// RevokeFamily revokes every token in a family and ends the session it belongs to
+func (rt *RefreshToken) RevokeFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update sessions set revoked_at = $1 where id = $2 and revoked_at is null`
+
+	_, err = db.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every refresh token belonging to a user and ends all of
+// their sessions
+func (rt *RefreshToken) RevokeAllForUser(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update sessions set revoked_at = $1 where user_id = $2 and revoked_at is null`
+
+	_, err = db.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeOthersForUser revokes every refresh token belonging to a user except those in
+// keepFamilyID, ending all of their other sessions
+func (rt *RefreshToken) RevokeOthersForUser(userID int, keepFamilyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and family_id <> $3 and revoked_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID, keepFamilyID)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update sessions set revoked_at = $1 where user_id = $2 and id <> $3 and revoked_at is null`
+
+	_, err = db.ExecContext(ctx, stmt, time.Now(), userID, keepFamilyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenUsable(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	revoked := sql.NullTime{Time: now.Add(-time.Hour), Valid: true}
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		revokedAt sql.NullTime
+		want      error
+	}{
+		{"live", now.Add(time.Hour), sql.NullTime{}, nil},
+		{"expires this instant", now, sql.NullTime{}, nil},
+		{"expired", now.Add(-time.Nanosecond), sql.NullTime{}, ErrTokenExpired},
+		{"revoked", now.Add(time.Hour), revoked, ErrTokenReused},
+		{"revoked and expired", now.Add(-time.Hour), revoked, ErrTokenReused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &RefreshToken{ExpiresAt: tt.expiresAt, RevokedAt: tt.revokedAt}
+			if err := token.usable(now); !errors.Is(err, tt.want) {
+				t.Errorf("usable = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	plainText, hash, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash != HashToken(plainText) {
+		t.Error("GenerateToken's hash isn't HashToken of its plain text")
+	}
+
+	other, _, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == plainText {
+		t.Error("GenerateToken returned the same token twice")
+	}
+}
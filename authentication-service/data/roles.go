@@ -0,0 +1,61 @@
+package data
+
+// The roles a user can have. Every account starts as RoleUser.
+const (
+	RoleUser    = "user"
+	RoleManager = "manager"
+	RoleAdmin   = "admin"
+)
+
+// Permissions understood by the services. They are put into access tokens so the
+// broker can authorize requests without calling back to us.
+const (
+	PermTasksRead   = "tasks:read"
+	PermTasksWrite  = "tasks:write"
+	PermUsersRead   = "users:read"
+	PermUsersWrite  = "users:write"
+	PermUsersDelete = "users:delete"
+	PermUsersInvite = "users:invite"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser:    {PermTasksRead, PermTasksWrite},
+	RoleManager: {PermTasksRead, PermTasksWrite, PermUsersRead, PermUsersInvite},
+	RoleAdmin:   {PermTasksRead, PermTasksWrite, PermUsersRead, PermUsersWrite, PermUsersDelete, PermUsersInvite},
+}
+
+// ValidRole reports whether role is one we know about.
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RolePermissions returns the permissions granted to role.
+func RolePermissions(role string) []string {
+	return rolePermissions[role]
+}
+
+// Permissions returns the permissions granted to the user's role.
+func (u *User) Permissions() []string {
+	return RolePermissions(u.Role)
+}
+
+// RoleIncludes reports whether every permission of role other is in perms.
+func RoleIncludes(perms []string, other string) bool {
+	for _, p := range rolePermissions[other] {
+		if !HasPermission(perms, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasPermission reports whether perm is in perms.
+func HasPermission(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
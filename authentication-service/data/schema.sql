@@ -0,0 +1,180 @@
+-- Tables used by the authentication service in addition to the users table.
+
+create table if not exists refresh_tokens (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    token_hash varchar(64) not null unique,
+    family_id varchar(64) not null,
+    expires_at timestamp not null,
+    revoked_at timestamp,
+    created_at timestamp not null default now()
+);
+
+create index if not exists refresh_tokens_family_id_idx on refresh_tokens (family_id);
+
+create table if not exists password_resets (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    token_hash varchar(64) not null unique,
+    expires_at timestamp not null,
+    used_at timestamp,
+    created_at timestamp not null default now()
+);
+
+-- existing accounts are treated as verified; new ones start unverified
+alter table users add column if not exists email_verified boolean not null default true;
+alter table users alter column email_verified set default false;
+
+alter table users add column if not exists role varchar(20) not null default 'user';
+
+create table if not exists user_totp (
+    user_id integer primary key references users (id) on delete cascade,
+    secret varchar(64) not null,
+    enabled boolean not null default false,
+    last_used_step bigint not null default 0,
+    created_at timestamp not null default now()
+);
+
+create table if not exists recovery_codes (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    code_hash varchar(64) not null,
+    used_at timestamp
+);
+
+create index if not exists recovery_codes_user_id_idx on recovery_codes (user_id);
+
+create table if not exists access_tokens (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    name varchar(100) not null,
+    token_hash varchar(64) not null unique,
+    scopes text not null default '',
+    expires_at timestamp,
+    last_used_at timestamp,
+    revoked_at timestamp,
+    created_at timestamp not null default now()
+);
+
+create index if not exists access_tokens_user_id_idx on access_tokens (user_id);
+
+alter table users add column if not exists failed_logins integer not null default 0;
+alter table users add column if not exists last_failed_login_at timestamp;
+alter table users add column if not exists locked_until timestamp;
+
+create table if not exists user_identities (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    issuer varchar(255) not null,
+    subject varchar(255) not null,
+    email varchar(255) not null default '',
+    created_at timestamp not null default now(),
+    unique (issuer, subject)
+);
+
+create table if not exists oidc_logins (
+    state_hash varchar(64) primary key,
+    nonce varchar(64) not null,
+    code_verifier varchar(128) not null,
+    expires_at timestamp not null
+);
+
+create table if not exists sessions (
+    id varchar(64) primary key,
+    user_id integer not null references users (id) on delete cascade,
+    user_agent varchar(255) not null default '',
+    ip varchar(64) not null default '',
+    created_at timestamp not null default now(),
+    last_seen_at timestamp not null default now(),
+    revoked_at timestamp
+);
+
+create index if not exists sessions_user_id_idx on sessions (user_id);
+create index if not exists sessions_revoked_at_idx on sessions (revoked_at);
+
+-- sessions are kept, revoked, when their user is deleted, so access tokens issued
+-- for them are turned away until they expire
+alter table sessions drop constraint if exists sessions_user_id_fkey;
+
+-- deliberately no foreign key: the user row is deleted when the deletion starts
+create table if not exists account_deletions (
+    id serial primary key,
+    user_id integer not null,
+    email varchar(255) not null,
+    attempts integer not null default 0,
+    requested_at timestamp not null default now(),
+    last_published_at timestamp,
+    completed_at timestamp
+);
+
+create table if not exists account_deletion_steps (
+    deletion_id integer not null references account_deletions (id) on delete cascade,
+    step varchar(50) not null,
+    completed_at timestamp,
+    primary key (deletion_id, step)
+);
+
+create table if not exists data_exports (
+    id serial primary key,
+    user_id integer not null references users (id) on delete cascade,
+    status varchar(20) not null,
+    error text not null default '',
+    token_hash varchar(64) unique,
+    archive bytea,
+    created_at timestamp not null default now(),
+    completed_at timestamp,
+    expires_at timestamp
+);
+
+create table if not exists invitations (
+    id serial primary key,
+    email varchar(255) not null,
+    role varchar(20) not null,
+    invited_by integer references users (id) on delete set null,
+    accepted_by integer references users (id) on delete set null,
+    expires_at timestamp not null,
+    accepted_at timestamp,
+    revoked_at timestamp,
+    created_at timestamp not null default now()
+);
+
+create index if not exists invitations_email_idx on invitations (lower(email));
+
+create table if not exists workspaces (
+    id serial primary key,
+    name varchar(100) not null,
+    created_by integer references users (id) on delete set null,
+    created_at timestamp not null default now()
+);
+
+create table if not exists workspace_members (
+    workspace_id integer not null references workspaces (id) on delete cascade,
+    user_id integer not null references users (id) on delete cascade,
+    role varchar(20) not null,
+    created_at timestamp not null default now(),
+    primary key (workspace_id, user_id)
+);
+
+create index if not exists workspace_members_user_id_idx on workspace_members (user_id);
+
+-- The first time this runs, give every existing user a personal workspace whose ID
+-- is their user ID. task-service/data/schema.sql relies on that to move existing
+-- tasks into their owner's workspace.
+do $$
+begin
+    if not exists (select 1 from workspaces) then
+        insert into workspaces (id, name, created_by) select id, 'Personal', id from users;
+        insert into workspace_members (workspace_id, user_id, role) select id, id, 'owner' from users;
+        perform setval('workspaces_id_seq', coalesce((select max(id) from workspaces), 0) + 1, false);
+    end if;
+end $$;
+
+-- the workspace a session's access tokens are issued for
+alter table sessions add column if not exists workspace_id integer not null default 0;
+
+-- the workspace a personal access token works in
+alter table access_tokens add column if not exists workspace_id integer not null default 0;
+
+-- the workspace an invitation adds its invitee to
+alter table invitations add column if not exists workspace_id integer references workspaces (id) on delete cascade;
+alter table invitations add column if not exists workspace_role varchar(20) not null default 'member';
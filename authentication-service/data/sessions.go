@@ -0,0 +1,221 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrSessionNotFound is returned when a session doesn't exist or belongs to
+// someone else.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is the structure which holds one login from the database. A session
+// starts when a user logs in and lasts as long as its refresh token family: the
+// session ID is the family ID, and revoking the family ends the session. Access
+// tokens are issued for the session's current workspace.
+type Session struct {
+	ID          string     `json:"id"`
+	UserID      int        `json:"user_id"`
+	UserAgent   string     `json:"user_agent"`
+	IP          string     `json:"ip"`
+	WorkspaceID int        `json:"workspace_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastSeenAt  time.Time  `json:"last_seen_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Create starts a new session for userID in a workspace and returns it.
+func (s *Session) Create(userID int, userAgent, ip string, workspaceID int) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	id, _, err := GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := Session{
+		ID:          id,
+		UserID:      userID,
+		UserAgent:   truncate(userAgent, 255),
+		IP:          ip,
+		WorkspaceID: workspaceID,
+		CreatedAt:   time.Now(),
+		LastSeenAt:  time.Now(),
+	}
+
+	stmt := `insert into sessions (id, user_id, user_agent, ip, workspace_id, created_at, last_seen_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = db.ExecContext(ctx, stmt,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.WorkspaceID,
+		session.CreatedAt,
+		session.LastSeenAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// Touch records that the session was just used from ip.
+func (s *Session) Touch(id, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update sessions set last_seen_at = $1, ip = $2 where id = $3`, time.Now(), ip, id)
+	return err
+}
+
+// SetWorkspace switches the workspace a session's access tokens are issued for.
+func (s *Session) SetWorkspace(id string, workspaceID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update sessions set workspace_id = $1 where id = $2`, workspaceID, id)
+	return err
+}
+
+// GetOne returns one session by id, or ErrSessionNotFound.
+func (s *Session) GetOne(id string) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, user_agent, ip, workspace_id, created_at, last_seen_at, revoked_at from sessions
+		where id = $1`
+
+	var session Session
+	err := db.QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.WorkspaceID,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetActiveForUser returns a user's sessions that haven't been revoked, most
+// recently used first.
+func (s *Session) GetActiveForUser(userID int) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, user_agent, ip, workspace_id, created_at, last_seen_at, revoked_at from sessions
+		where user_id = $1 and revoked_at is null order by last_seen_at desc`
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IP,
+			&session.WorkspaceID,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.RevokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// IsRevoked reports whether a session has been revoked. Tokens issued before
+// sessions were tracked name sessions that don't exist; those aren't revoked.
+func (s *Session) IsRevoked(id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var revokedAt *time.Time
+	err := db.QueryRowContext(ctx, `select revoked_at from sessions where id = $1`, id).Scan(&revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return revokedAt != nil, nil
+}
+
+// RevokedSince returns the IDs of sessions revoked after since.
+func (s *Session) RevokedSince(since time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `select id from sessions where revoked_at > $1`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Revoke ends one of userID's sessions, along with its refresh tokens.
+func (s *Session) Revoke(id string, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var found bool
+	err := db.QueryRowContext(ctx, `select exists(select 1 from sessions where id = $1 and user_id = $2)`,
+		id, userID).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrSessionNotFound
+	}
+
+	var rt RefreshToken
+	return rt.RevokeFamily(id)
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
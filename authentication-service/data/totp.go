@@ -0,0 +1,304 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many periods either side of now a code is accepted for, to
+	// allow for clock drift on the user's device.
+	totpSkew = 1
+	// recoveryCodeCount is how many recovery codes a user gets when they enroll.
+	recoveryCodeCount = 10
+)
+
+// ErrTOTPNotEnrolled is returned when a user has no TOTP secret.
+var ErrTOTPNotEnrolled = errors.New("two-factor authentication is not set up")
+
+// TOTP is the structure which holds one user's time-based one-time password
+// (RFC 6238) settings. A secret is pending until the user proves they can generate
+// codes from it, at which point Enabled is set.
+type TOTP struct {
+	UserID       int       `json:"user_id"`
+	Secret       string    `json:"-"`
+	Enabled      bool      `json:"enabled"`
+	LastUsedStep int64     `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// NewTOTPSecret returns a random base32 encoded secret.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// TOTPCode computes the code for secret at the given time step.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps read from a QR code.
+func (t *TOTP) ProvisioningURI(issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", t.Secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Validate checks code against the secret and, if it matches, records the time step
+// it was generated for. A code can only be used once: a step at or before the last
+// one used is rejected, so an intercepted code can't be replayed.
+func (t *TOTP) Validate(code string) (bool, error) {
+	step, ok, err := t.matchStep(code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return t.markUsed(step)
+}
+
+// matchStep finds the time step around now that code was generated for, skipping
+// steps that were already used.
+func (t *TOTP) matchStep(code string, now time.Time) (int64, bool, error) {
+	current := now.Unix() / totpPeriod
+
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := current + int64(i)
+		if step <= t.LastUsedStep {
+			continue
+		}
+
+		expected, err := TOTPCode(t.Secret, step)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+func (t *TOTP) markUsed(step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, `update user_totp set last_used_step = $1 where user_id = $2 and last_used_step < $1`,
+		step, t.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	// zero rows means a concurrent request already used this step
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	t.LastUsedStep = step
+	return n == 1, nil
+}
+
+// GetByUserID returns the TOTP settings for a user, or ErrTOTPNotEnrolled.
+func (t *TOTP) GetByUserID(userID int) (*TOTP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select user_id, secret, enabled, last_used_step, created_at from user_totp where user_id = $1`
+
+	var totp TOTP
+	err := db.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastUsedStep,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTOTPNotEnrolled
+		}
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+// Enroll stores a new, not yet enabled, secret for userID, replacing any pending one.
+// It refuses to replace a secret that is already enabled.
+func (t *TOTP) Enroll(userID int) (*TOTP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `insert into user_totp (user_id, secret, enabled, last_used_step, created_at)
+		values ($1, $2, false, 0, $3)
+		on conflict (user_id) do update set secret = excluded.secret, last_used_step = 0, created_at = excluded.created_at
+		where user_totp.enabled = false`
+
+	res, err := db.ExecContext(ctx, stmt, userID, secret, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	return &TOTP{UserID: userID, Secret: secret, CreatedAt: time.Now()}, nil
+}
+
+// Enable turns on TOTP for the user and returns a fresh set of recovery codes.
+func (t *TOTP) Enable() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update user_totp set enabled = true where user_id = $1`, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Enabled = true
+
+	var rc RecoveryCode
+	return rc.Regenerate(t.UserID)
+}
+
+// Disable removes the user's TOTP secret and recovery codes.
+func (t *TOTP) Disable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `delete from user_totp where user_id = $1`, t.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, t.UserID)
+	return err
+}
+
+// RecoveryCode is one single-use code that can stand in for a TOTP code when the
+// user has lost their device. Only hashes are stored.
+type RecoveryCode struct {
+	ID       int          `json:"id"`
+	UserID   int          `json:"user_id"`
+	CodeHash string       `json:"-"`
+	UsedAt   sql.NullTime `json:"-"`
+}
+
+// Regenerate replaces all of a user's recovery codes and returns the new plain text codes.
+func (rc *RecoveryCode) Regenerate(userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	var codes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		raw := strings.ToLower(enc.EncodeToString(b))
+		code := raw[:5] + "-" + raw[5:10]
+
+		_, err = tx.ExecContext(ctx, `insert into recovery_codes (user_id, code_hash) values ($1, $2)`,
+			userID, HashToken(code))
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Consume uses up one of the user's recovery codes. It reports false if the code
+// doesn't exist or was already used.
+func (rc *RecoveryCode) Consume(userID int, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	res, err := db.ExecContext(ctx, `update recovery_codes set used_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`,
+		time.Now(), userID, HashToken(code))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
@@ -0,0 +1,97 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 test secret "12345678901234567890" in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the RFC 6238 SHA1 vectors, cut down to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPMatchStep(t *testing.T) {
+	// 081804 is the code for step 37037036, the 30 seconds starting at unix time
+	// 1111111080
+	const (
+		code  = "081804"
+		step  = 37037036
+		start = step * totpPeriod
+	)
+
+	tests := []struct {
+		name     string
+		unix     int64
+		lastUsed int64
+		wantOK   bool
+	}{
+		{"last second two steps early", start - 31, 0, false},
+		{"first second one step early", start - 30, 0, true},
+		{"last second one step early", start - 1, 0, true},
+		{"first second of its step", start, 0, true},
+		{"last second of its step", start + 29, 0, true},
+		{"first second one step late", start + 30, 0, true},
+		{"last second one step late", start + 59, 0, true},
+		{"first second two steps late", start + 60, 0, false},
+		{"earlier step used", start, step - 1, true},
+		{"step already used", start, step, false},
+		{"later step used", start, step + 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totp := &TOTP{Secret: rfcSecret, LastUsedStep: tt.lastUsed}
+
+			got, ok, err := totp.matchStep(code, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("matchStep at %d reported %t, want %t", tt.unix, ok, tt.wantOK)
+			}
+			if ok && got != step {
+				t.Errorf("matchStep at %d matched step %d, want %d", tt.unix, got, step)
+			}
+		})
+	}
+}
+
+func TestTOTPMatchStepWrongCode(t *testing.T) {
+	totp := &TOTP{Secret: rfcSecret}
+
+	for _, code := range []string{"", "000000", "28708", "2870820", "287083"} {
+		if _, ok, _ := totp.matchStep(code, time.Unix(59, 0)); ok {
+			t.Errorf("matchStep accepted %q", code)
+		}
+	}
+}
+
+func TestTOTPMatchStepBadSecret(t *testing.T) {
+	totp := &TOTP{Secret: "not base32!"}
+
+	if _, _, err := totp.matchStep("287082", time.Unix(59, 0)); err == nil {
+		t.Error("matchStep with a bad secret returned no error")
+	}
+}
@@ -0,0 +1,267 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The roles a user can have within a workspace. They are separate from the
+// account-wide roles in roles.go.
+const (
+	WorkspaceOwner  = "owner"
+	WorkspaceAdmin  = "admin"
+	WorkspaceMember = "member"
+)
+
+var (
+	// ErrWorkspaceNotFound is returned when a workspace doesn't exist or the user
+	// isn't a member of it.
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	// ErrLastOwner is returned when a change would leave a workspace without an owner.
+	ErrLastOwner = errors.New("a workspace must keep at least one owner")
+)
+
+// ValidWorkspaceRole reports whether role is a workspace role we know about.
+func ValidWorkspaceRole(role string) bool {
+	return role == WorkspaceOwner || role == WorkspaceAdmin || role == WorkspaceMember
+}
+
+// CanManageWorkspace reports whether a member with role may invite, remove and
+// change the roles of other members.
+func CanManageWorkspace(role string) bool {
+	return role == WorkspaceOwner || role == WorkspaceAdmin
+}
+
+// Workspace is the structure which holds one workspace from the database. A
+// workspace is the boundary tasks are shared within; users join workspaces through
+// memberships and can belong to several.
+type Workspace struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy *int      `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership is one user's place in one workspace.
+type Membership struct {
+	WorkspaceID   int       `json:"workspace_id"`
+	WorkspaceName string    `json:"workspace_name"`
+	UserID        int       `json:"user_id"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Create makes a new workspace with ownerID as its only member.
+func (w *Workspace) Create(name string, ownerID int) (*Workspace, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	workspace := Workspace{
+		Name:      name,
+		CreatedBy: &ownerID,
+		CreatedAt: time.Now(),
+	}
+
+	err = tx.QueryRowContext(ctx, `insert into workspaces (name, created_by, created_at) values ($1, $2, $3) returning id`,
+		workspace.Name, ownerID, workspace.CreatedAt).Scan(&workspace.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into workspace_members (workspace_id, user_id, role, created_at) values ($1, $2, $3, $4)`,
+		workspace.ID, ownerID, WorkspaceOwner, workspace.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+// GetOne returns one workspace by id, or ErrWorkspaceNotFound.
+func (w *Workspace) GetOne(id int) (*Workspace, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var workspace Workspace
+	err := db.QueryRowContext(ctx, `select id, name, created_by, created_at from workspaces where id = $1`, id).Scan(
+		&workspace.ID,
+		&workspace.Name,
+		&workspace.CreatedBy,
+		&workspace.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWorkspaceNotFound
+		}
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+const membershipQuery = `select m.workspace_id, w.name, m.user_id, u.email, m.role, m.created_at
+	from workspace_members m
+	join workspaces w on w.id = m.workspace_id
+	join users u on u.id = m.user_id`
+
+func scanMemberships(rows *sql.Rows) ([]*Membership, error) {
+	defer rows.Close()
+
+	var memberships []*Membership
+
+	for rows.Next() {
+		var m Membership
+		err := rows.Scan(
+			&m.WorkspaceID,
+			&m.WorkspaceName,
+			&m.UserID,
+			&m.Email,
+			&m.Role,
+			&m.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		memberships = append(memberships, &m)
+	}
+
+	return memberships, rows.Err()
+}
+
+// GetForUser returns the workspaces userID belongs to, oldest membership first.
+func (w *Workspace) GetForUser(userID int) ([]*Membership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, membershipQuery+` where m.user_id = $1 order by m.created_at, m.workspace_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanMemberships(rows)
+}
+
+// Members returns everyone in a workspace, sorted by email.
+func (w *Workspace) Members(workspaceID int) ([]*Membership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, membershipQuery+` where m.workspace_id = $1 order by u.email`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanMemberships(rows)
+}
+
+// Membership returns userID's membership of a workspace, or ErrWorkspaceNotFound.
+func (w *Workspace) Membership(workspaceID, userID int) (*Membership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, membershipQuery+` where m.workspace_id = $1 and m.user_id = $2`, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberships, err := scanMemberships(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(memberships) == 0 {
+		return nil, ErrWorkspaceNotFound
+	}
+
+	return memberships[0], nil
+}
+
+// AddMember puts userID into a workspace with role. Someone who is already a member
+// keeps the role they have.
+func (w *Workspace) AddMember(workspaceID, userID int, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into workspace_members (workspace_id, user_id, role, created_at) values ($1, $2, $3, $4)
+		on conflict (workspace_id, user_id) do nothing`
+
+	_, err := db.ExecContext(ctx, stmt, workspaceID, userID, role, time.Now())
+	return err
+}
+
+// SetMemberRole changes a member's role. The last owner can't be demoted.
+func (w *Workspace) SetMemberRole(workspaceID, userID int, role string) error {
+	return changeMembership(workspaceID, userID, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `update workspace_members set role = $1 where workspace_id = $2 and user_id = $3`,
+			role, workspaceID, userID)
+		return err
+	})
+}
+
+// RemoveMember takes userID out of a workspace. The last owner can't be removed.
+func (w *Workspace) RemoveMember(workspaceID, userID int) error {
+	return changeMembership(workspaceID, userID, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `delete from workspace_members where workspace_id = $1 and user_id = $2`,
+			workspaceID, userID)
+		return err
+	})
+}
+
+// changeMembership runs change and then makes sure the workspace still has an
+// owner. The workspace's memberships are locked meanwhile, so two owners can't
+// demote each other at the same time.
+func changeMembership(workspaceID, userID int, change func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found bool
+	err = tx.QueryRowContext(ctx, `select exists(select 1 from workspace_members where workspace_id = $1 and user_id = $2)`,
+		workspaceID, userID).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrWorkspaceNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `select 1 from workspace_members where workspace_id = $1 for update`, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	if err := change(ctx, tx); err != nil {
+		return err
+	}
+
+	var owners int
+	err = tx.QueryRowContext(ctx, `select count(*) from workspace_members where workspace_id = $1 and role = $2`,
+		workspaceID, WorkspaceOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,99 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// completionsQueue collects DeletionCompletedEvents for the authentication service.
+const completionsQueue = "authentication-service.user.deletion.completed"
+
+type Consumer struct {
+	conn *amqp.Connection
+}
+
+func NewConsumer(conn *amqp.Connection) (Consumer, error) {
+	consumer := Consumer{
+		conn: conn,
+	}
+
+	err := consumer.setup()
+	if err != nil {
+		return Consumer{}, err
+	}
+	return consumer, nil
+}
+
+func (consumer *Consumer) setup() error {
+	channel, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	return declareExchange(channel)
+}
+
+// ListenForCompletions calls handle for every DeletionCompletedEvent, with a context
+// carrying the trace the event was published with. Messages that can't be handled
+// are dropped: the deletion stays pending and is published again later, which
+// makes the service report again. It blocks until the connection closes.
+func (consumer *Consumer) ListenForCompletions(handle func(context.Context, DeletionCompletedEvent) error) error {
+	ch, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	q, err := declareDurableQueue(ch, completionsQueue)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(q.Name, UserDeletionCompleted, UsersExchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	messages, err := ch.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Waiting for message [Exchange, Queue] [%s, %s]\n", UsersExchange, q.Name)
+
+	for d := range messages {
+		ctx, span := tracing.StartConsume(context.Background(), q.Name, d)
+
+		var payload DeletionCompletedEvent
+		if err := json.Unmarshal(d.Body, &payload); err != nil {
+			log.Println("Dropping malformed deletion completed event", err)
+			tracing.RecordError(span, err)
+			metrics.Consumed(q.Name, err)
+			span.End()
+			_ = d.Ack(false)
+			continue
+		}
+
+		if err := handle(ctx, payload); err != nil {
+			log.Println("Error handling deletion completed event", err)
+			tracing.RecordError(span, err)
+			metrics.Consumed(q.Name, err)
+			span.End()
+			_ = d.Nack(false, false)
+			continue
+		}
+
+		span.End()
+		metrics.Consumed(q.Name, nil)
+		_ = d.Ack(false)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type Emitter struct {
+	connection *amqp.Connection
+}
+
+func (e *Emitter) SetUp() error {
+	channel, err := e.connection.Channel()
+	if err != nil {
+		return err
+	}
+
+	defer channel.Close()
+	return declareExchange(channel)
+}
+
+// Publish sends payload, encoded as JSON, to UsersExchange with the given routing key.
+// Messages are persistent so they survive a broker restart, and carry the trace
+// ctx belongs to.
+func (e *Emitter) Publish(ctx context.Context, routingKey string, payload any) (err error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ch, err := e.connection.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	headers := amqp.Table{}
+	ctx, span := tracing.StartPublish(ctx, UsersExchange, routingKey, headers)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+		metrics.Published(UsersExchange, routingKey, err)
+	}()
+
+	return ch.PublishWithContext(ctx,
+		UsersExchange,
+		routingKey,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Headers:      headers,
+			Body:         body,
+		})
+}
+
+func NewEventEmitter(conn *amqp.Connection) (Emitter, error) {
+	emitter := Emitter{
+		connection: conn,
+	}
+
+	err := emitter.SetUp()
+	if err != nil {
+		return Emitter{}, err
+	}
+	return emitter, nil
+}
@@ -0,0 +1,54 @@
+package event
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// UsersExchange carries events about user accounts that other services act on.
+const UsersExchange = "users_topic"
+
+// Routing keys used on UsersExchange.
+const (
+	UserDeleted           = "user.deleted"
+	UserDeletionCompleted = "user.deletion.completed"
+)
+
+// UserDeletedEvent is published when an account is deleted. Every service holding
+// data about the user removes it and answers with a DeletionCompletedEvent.
+type UserDeletedEvent struct {
+	DeletionID int    `json:"deletion_id"`
+	UserID     int    `json:"user_id"`
+	Email      string `json:"email"`
+}
+
+// DeletionCompletedEvent reports that one service has finished its part of a deletion.
+type DeletionCompletedEvent struct {
+	DeletionID int    `json:"deletion_id"`
+	UserID     int    `json:"user_id"`
+	Service    string `json:"service"`
+}
+
+func declareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		UsersExchange, // name
+		"topic",       // type
+		true,          // durable
+		false,         // auto-deleted
+		false,         // internal
+		false,         // no-wait
+		nil,           // arguments
+	)
+}
+
+// declareDurableQueue declares a named queue that survives restarts, so events sent
+// while the service is down are delivered when it comes back.
+func declareDurableQueue(ch *amqp.Channel, name string) (amqp.Queue, error) {
+	return ch.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DaffaJatmiko/broker-service/users"
+)
+
+const (
+	// accessTokenPrefix starts every personal access token the authentication
+	// service issues, which is how JWTMiddleware tells them apart from JWTs.
+	accessTokenPrefix = "tmpat_"
+	// introspectCacheTTL bounds how long a revoked access token keeps working.
+	introspectCacheTTL = 30 * time.Second
+)
+
+type introspection struct {
+	claims  *Claims
+	expires time.Time
+}
+
+// AccessTokenCache remembers recent introspection results so that scripts making
+// many calls don't cost a round trip to the authentication service each time.
+type AccessTokenCache struct {
+	users   users.UserServiceClient
+	timeout time.Duration
+	mu      sync.Mutex
+	entries map[string]introspection
+}
+
+// NewAccessTokenCache returns a cache that asks client about tokens it hasn't seen,
+// waiting at most timeout for an answer.
+func NewAccessTokenCache(client users.UserServiceClient, timeout time.Duration) *AccessTokenCache {
+	return &AccessTokenCache{users: client, timeout: timeout, entries: make(map[string]introspection)}
+}
+
+// Claims returns the claims for a personal access token, asking the authentication
+// service to validate it unless a recent answer is cached.
+func (c *AccessTokenCache) Claims(token string) (*Claims, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[token]
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.claims, nil
+	}
+
+	claims, err := c.introspect(token)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// drop stale entries so tokens that are no longer used don't pile up
+	for k, v := range c.entries {
+		if time.Now().After(v.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[token] = introspection{claims: claims, expires: time.Now().Add(introspectCacheTTL)}
+
+	return claims, nil
+}
+
+// introspect asks the authentication service's ValidateToken call for the claims a
+// personal access token stands for.
+func (c *AccessTokenCache) introspect(token string) (*Claims, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	res, err := c.users.ValidateToken(ctx, &users.ValidateTokenRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{
+		Email:         res.GetEmail(),
+		UserID:        int(res.GetUserId()),
+		Role:          res.GetRole(),
+		Permissions:   res.GetPermissions(),
+		SessionID:     res.GetSessionId(),
+		WorkspaceID:   int(res.GetWorkspaceId()),
+		WorkspaceRole: res.GetWorkspaceRole(),
+	}
+	claims.Subject = strconv.Itoa(claims.UserID)
+	claims.Audience = accessTokenAudience
+	if res.GetExpiresAt() != nil {
+		claims.ExpiresAt = res.GetExpiresAt().AsTime().Unix()
+	}
+
+	return claims, nil
+}
+
+// isAccessToken reports whether tokenString is a personal access token rather than a JWT.
+func isAccessToken(tokenString string) bool {
+	return strings.HasPrefix(tokenString, accessTokenPrefix)
+}
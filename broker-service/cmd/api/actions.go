@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/DaffaJatmiko/shared/requestid"
+)
+
+// authRequirement says what a caller must present before the broker runs an action.
+type authRequirement int
+
+const (
+	// authNone actions are open to anyone.
+	authNone authRequirement = iota
+	// authUser actions pass the caller's Authorization header on; the upstream checks it.
+	authUser
+	// authWorkspace actions are only served on /handle-task, where the broker has
+	// verified the token and filled in the workspace it was issued for.
+	authWorkspace
+)
+
+// failure is the answer the broker gives when an upstream replies with a given status.
+type failure struct {
+	// message replaces the upstream's message; empty passes the upstream's on.
+	message string
+	status  int
+}
+
+// action describes how the broker proxies one action to an upstream.
+type action struct {
+	upstream string
+	method   string
+	path     string
+	// pathFor builds the path from the request instead, for paths that carry ids.
+	pathFor func(p *RequestPayload) string
+	// body picks the part of the request sent upstream; nil sends no body.
+	body func(p *RequestPayload) interface{}
+	auth authRequirement
+	// forwardClient sends the caller's address as X-Forwarded-For and passes its
+	// User-Agent on, for upstreams that throttle per client or record sessions.
+	forwardClient bool
+
+	// expect is the status the upstream answers with on success. Zero accepts any
+	// status and goes by the error flag in the upstream's response instead.
+	expect int
+	// failures maps upstream statuses to the error the broker answers with.
+	failures map[int]failure
+	// unexpected is the message for any other status; empty passes the upstream's on.
+	unexpected string
+
+	// message is what the broker tells the caller on success. When it and messageFor
+	// are empty, the upstream's response is relayed as is.
+	message    string
+	messageFor func(p *RequestPayload) string
+	// data passes the upstream's data on to the caller.
+	data   bool
+	status int
+}
+
+const (
+	errCallingAuth = "error calling auth service"
+	errCallingTask = "error calling task service"
+)
+
+// actions maps each action the broker accepts to the upstream call that serves it.
+// Adding an upstream action only needs an entry here.
+var actions = map[string]action{
+	"register": {
+		upstream:   authService,
+		method:     http.MethodPost,
+		path:       "/register",
+		body:       func(p *RequestPayload) interface{} { return p.Register },
+		expect:     http.StatusCreated,
+		unexpected: "error calling registration service",
+		message:    "Registered!",
+		data:       true,
+		status:     http.StatusCreated,
+	},
+	"auth": {
+		upstream:      authService,
+		method:        http.MethodPost,
+		path:          "/authenticate",
+		body:          func(p *RequestPayload) interface{} { return p.Auth },
+		forwardClient: true,
+		expect:        http.StatusAccepted,
+		failures: map[int]failure{
+			http.StatusUnauthorized: {message: "invalid creds", status: http.StatusBadRequest},
+			// unverified or inactive account, or throttled and locked out
+			http.StatusForbidden:       {status: http.StatusForbidden},
+			http.StatusTooManyRequests: {status: http.StatusTooManyRequests},
+		},
+		unexpected: errCallingAuth,
+		message:    "Authenticated!",
+		data:       true,
+		status:     http.StatusAccepted,
+	},
+	// auth_totp completes a login for users with two-factor authentication,
+	// exchanging the mfa_token from the auth action and a code for real tokens.
+	"auth_totp": {
+		upstream:      authService,
+		method:        http.MethodPost,
+		path:          "/authenticate/totp",
+		body:          func(p *RequestPayload) interface{} { return p.AuthTOTP },
+		forwardClient: true,
+		expect:        http.StatusAccepted,
+		failures: map[int]failure{
+			http.StatusUnauthorized:    {status: http.StatusUnauthorized},
+			http.StatusTooManyRequests: {status: http.StatusTooManyRequests},
+		},
+		unexpected: errCallingAuth,
+		message:    "Authenticated!",
+		data:       true,
+		status:     http.StatusAccepted,
+	},
+	"refresh": {
+		upstream:      authService,
+		method:        http.MethodPost,
+		path:          "/refresh",
+		body:          func(p *RequestPayload) interface{} { return p.Refresh },
+		forwardClient: true,
+		expect:        http.StatusAccepted,
+		failures: map[int]failure{
+			http.StatusUnauthorized: {message: "invalid refresh token", status: http.StatusUnauthorized},
+		},
+		unexpected: errCallingAuth,
+		message:    "Refreshed!",
+		data:       true,
+		status:     http.StatusAccepted,
+	},
+	"logout": {
+		upstream: authService,
+		method:   http.MethodPost,
+		path:     "/logout",
+		body:     func(p *RequestPayload) interface{} { return p.Logout },
+		expect:   http.StatusAccepted,
+		failures: map[int]failure{
+			http.StatusUnauthorized: {message: "invalid refresh token", status: http.StatusUnauthorized},
+		},
+		unexpected: errCallingAuth,
+		message:    "Logged out!",
+		status:     http.StatusAccepted,
+	},
+	"request_password_reset": {
+		upstream:   authService,
+		method:     http.MethodPost,
+		path:       "/password-reset/request",
+		body:       func(p *RequestPayload) interface{} { return p.PasswordResetRequest },
+		expect:     http.StatusAccepted,
+		unexpected: errCallingAuth,
+		message:    "If that email is registered, a password reset link has been sent",
+		status:     http.StatusAccepted,
+	},
+	"confirm_password_reset": {
+		upstream: authService,
+		method:   http.MethodPost,
+		path:     "/password-reset/confirm",
+		body:     func(p *RequestPayload) interface{} { return p.PasswordResetConfirm },
+		expect:   http.StatusAccepted,
+		message:  "Password reset!",
+		status:   http.StatusAccepted,
+	},
+	"verify_email": {
+		upstream: authService,
+		method:   http.MethodPost,
+		path:     "/verify-email",
+		body:     func(p *RequestPayload) interface{} { return p.VerifyEmail },
+		expect:   http.StatusAccepted,
+		message:  "Email verified!",
+		status:   http.StatusAccepted,
+	},
+	"resend_verification": {
+		upstream: authService,
+		method:   http.MethodPost,
+		path:     "/verify-email/resend",
+		body:     func(p *RequestPayload) interface{} { return p.ResendVerification },
+		expect:   http.StatusAccepted,
+		failures: map[int]failure{
+			http.StatusTooManyRequests: {message: "too many verification emails requested, try again later", status: http.StatusTooManyRequests},
+		},
+		unexpected: errCallingAuth,
+		message:    "If that email is registered and unverified, a verification link has been sent",
+		status:     http.StatusAccepted,
+	},
+
+	// profile actions act as the caller, so the authentication service checks the token
+	"get_profile": {
+		upstream: authService,
+		method:   http.MethodGet,
+		path:     "/me",
+		auth:     authUser,
+	},
+	"update_profile": {
+		upstream: authService,
+		method:   http.MethodPut,
+		path:     "/me",
+		body:     func(p *RequestPayload) interface{} { return p.UpdateProfile },
+		auth:     authUser,
+	},
+	"change_password": {
+		upstream: authService,
+		method:   http.MethodPost,
+		path:     "/me/password",
+		body:     func(p *RequestPayload) interface{} { return p.ChangePassword },
+		auth:     authUser,
+	},
+	"change_email": {
+		upstream: authService,
+		method:   http.MethodPost,
+		path:     "/me/email",
+		body:     func(p *RequestPayload) interface{} { return p.ChangeEmail },
+		auth:     authUser,
+	},
+	"confirm_email_change": {
+		upstream: authService,
+		method:   http.MethodPost,
+		path:     "/me/email/confirm",
+		// the token in the link is the proof, as with password resets; the link
+		// may well be opened somewhere the user isn't logged in
+		body: func(p *RequestPayload) interface{} { return p.ConfirmEmailChange },
+	},
+	"request_export": {
+		upstream: authService,
+		method:   http.MethodPost,
+		path:     "/me/export",
+		auth:     authUser,
+	},
+	"export_status": {
+		upstream: authService,
+		method:   http.MethodGet,
+		pathFor:  func(p *RequestPayload) string { return fmt.Sprintf("/me/export/%d", p.ExportStatus.ID) },
+		auth:     authUser,
+	},
+
+	"mail": {
+		upstream:   mailService,
+		method:     http.MethodPost,
+		path:       "/send",
+		body:       func(p *RequestPayload) interface{} { return p.Mail },
+		expect:     http.StatusAccepted,
+		unexpected: "error calling mail service",
+		messageFor: func(p *RequestPayload) string { return "Message sent to " + p.Mail.To },
+		status:     http.StatusAccepted,
+	},
+
+	"add_task": {
+		upstream:   taskService,
+		method:     http.MethodPost,
+		path:       "/tasks",
+		body:       func(p *RequestPayload) interface{} { return p.AddTask },
+		auth:       authWorkspace,
+		expect:     http.StatusCreated,
+		unexpected: errCallingTask,
+		message:    "Success added task!",
+		data:       true,
+		status:     http.StatusCreated,
+	},
+	"get_tasks_by_user_id": {
+		upstream:   taskService,
+		method:     http.MethodGet,
+		path:       "/tasks/userId",
+		body:       func(p *RequestPayload) interface{} { return p.GetTask },
+		auth:       authWorkspace,
+		expect:     http.StatusOK,
+		unexpected: errCallingTask,
+		message:    "Success getting tasks!",
+		data:       true,
+		status:     http.StatusCreated,
+	},
+	"update_task": {
+		upstream:   taskService,
+		method:     http.MethodPut,
+		path:       "/tasks/update",
+		body:       func(p *RequestPayload) interface{} { return p.UpdateTask },
+		auth:       authWorkspace,
+		expect:     http.StatusAccepted,
+		unexpected: errCallingTask,
+		message:    "Success updated task!",
+		data:       true,
+		status:     http.StatusCreated,
+	},
+	"delete_task": {
+		upstream:   taskService,
+		method:     http.MethodDelete,
+		path:       "/tasks/delete",
+		body:       func(p *RequestPayload) interface{} { return p.DeleteTask },
+		auth:       authWorkspace,
+		expect:     http.StatusAccepted,
+		unexpected: errCallingTask,
+		message:    "Success deleted task!",
+		data:       true,
+		status:     http.StatusOK,
+	},
+}
+
+// proxy runs a, calling its upstream with the relevant part of p and answering the
+// caller according to how the upstream replied.
+func (app *Config) proxy(w http.ResponseWriter, r *http.Request, a action, p *RequestPayload) {
+	var body bytes.Buffer
+	if a.body != nil {
+		if err := json.NewEncoder(&body).Encode(a.body(p)); err != nil {
+			log.Println("Error marshalling data", err)
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	path := a.path
+	if a.pathFor != nil {
+		path = a.pathFor(p)
+	}
+
+	request, err := http.NewRequestWithContext(r.Context(), a.method, app.endpoint(a.upstream, path), &body)
+	if err != nil {
+		log.Println("Error creating request", err)
+		app.errorJSON(w, err)
+		return
+	}
+
+	if a.body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if a.auth == authUser {
+		authorization := r.Header.Get("Authorization")
+		if authorization == "" {
+			app.errorJSON(w, errors.New("authorization token not found"), http.StatusUnauthorized)
+			return
+		}
+		request.Header.Set("Authorization", authorization)
+	}
+	if a.forwardClient {
+		forwardClient(request, r)
+	}
+
+	response, err := app.httpClient(a.upstream).Do(request)
+	if err != nil {
+		log.Println("Error calling", a.upstream, err, "request", requestid.FromContext(r.Context()))
+		app.errorJSON(w, err, upstreamErrorStatus(err))
+		return
+	}
+	defer response.Body.Close()
+
+	log.Println("Received status code from", a.upstream+":", response.StatusCode, "request", requestid.FromContext(r.Context()))
+
+	// error responses usually say why, so the body is read whatever the status
+	var jsonFromService jsonResponse
+	raw, err := io.ReadAll(response.Body)
+	if err == nil {
+		err = json.Unmarshal(raw, &jsonFromService)
+	}
+	decoded := err == nil
+	upstreamMessage := jsonFromService.Message
+	if upstreamMessage == "" {
+		upstreamMessage = "error calling " + strings.TrimSuffix(a.upstream, "-service") + " service"
+	}
+
+	if f, ok := a.failures[response.StatusCode]; ok {
+		message := f.message
+		if message == "" {
+			message = upstreamMessage
+		}
+		if retryAfter := response.Header.Get("Retry-After"); retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		app.errorJSON(w, errors.New(message), f.status)
+		return
+	}
+
+	if a.expect != 0 && response.StatusCode != a.expect {
+		log.Println("Wrong status code from", a.upstream, response.StatusCode)
+		message := a.unexpected
+		if message == "" {
+			message = upstreamMessage
+		}
+		app.errorJSON(w, errors.New(message))
+		return
+	}
+
+	if !decoded && (a.data || a.expect == 0) {
+		log.Println("Error decoding response from", a.upstream, err)
+		app.errorJSON(w, errors.New(upstreamMessage), http.StatusBadGateway)
+		return
+	}
+
+	if jsonFromService.Error {
+		status := response.StatusCode
+		if status < http.StatusBadRequest {
+			status = http.StatusBadGateway
+		}
+		app.errorJSON(w, errors.New(upstreamMessage), status)
+		return
+	}
+
+	if a.message == "" && a.messageFor == nil {
+		app.writeJSON(w, response.StatusCode, jsonFromService)
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = a.message
+	if a.messageFor != nil {
+		payload.Message = a.messageFor(p)
+	}
+	if a.data {
+		payload.Data = jsonFromService.Data
+	}
+
+	app.writeJSON(w, a.status, payload)
+}
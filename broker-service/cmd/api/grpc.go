@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DaffaJatmiko/broker-service/tasks"
+	"github.com/DaffaJatmiko/shared/config"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/serviceauth"
+	"github.com/DaffaJatmiko/shared/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// TaskJSON mirrors the JSON shape task-service returns over HTTP, so clients
+// get the same payload whichever transport the broker uses.
+type TaskJSON struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	UserID      int       `json:"user_id"`
+	WorkspaceID int       `json:"workspace_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func taskFromPB(t *tasks.Task) TaskJSON {
+	return TaskJSON{
+		ID:          int(t.GetId()),
+		Name:        t.GetName(),
+		Description: t.GetDescription(),
+		UserID:      int(t.GetUserId()),
+		WorkspaceID: int(t.GetWorkspaceId()),
+		CreatedAt:   t.GetCreatedAt().AsTime(),
+		UpdatedAt:   t.GetUpdatedAt().AsTime(),
+	}
+}
+
+// taskClient sets up a connection to task-service's gRPC server. Like userClient it
+// is kept for the life of the broker and doesn't wait for the service to be up;
+// each call has until the upstream's timeout to get through.
+func taskClient(upstream config.Upstream, serviceAuth *serviceauth.Service) (tasks.TaskServiceClient, error) {
+	conn, err := grpc.Dial(upstream.GRPC,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(serviceAuth.Credentials(taskService)),
+		grpc.WithChainUnaryInterceptor(requestid.UnaryClientInterceptor(), metrics.UnaryClientInterceptor(taskService)),
+		grpc.WithStreamInterceptor(requestid.StreamClientInterceptor()),
+		tracing.DialOption())
+	if err != nil {
+		return nil, err
+	}
+
+	return tasks.NewTaskServiceClient(conn), nil
+}
+
+// grpcError answers for a failed gRPC call to upstream. Only the messages of
+// errors about the request itself are passed on; anything else may describe the
+// upstream's internals, so the caller just hears that the call failed.
+func (app *Config) grpcError(w http.ResponseWriter, upstream string, err error) {
+	log.Println("Error calling", upstream, "via gRPC", err, "request", w.Header().Get(requestid.Header))
+
+	message := "error calling " + strings.TrimSuffix(upstream, "-service") + " service"
+	switch st, _ := status.FromError(err); st.Code() {
+	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied:
+		message = st.Message()
+	}
+
+	app.errorJSON(w, errors.New(message), grpcStatus(err))
+}
+
+// grpcStatus maps a gRPC status code onto the closest HTTP status.
+func grpcStatus(err error) int {
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleTaskServiceViaGRPC dispatches task actions to task-service's gRPC API
+// instead of its HTTP endpoints. It is used when TASK_TRANSPORT=grpc.
+func (app *Config) handleTaskServiceViaGRPC(ctx context.Context, w http.ResponseWriter, requestPayload RequestPayload) {
+	switch requestPayload.Action {
+	case "add_task":
+		app.addTaskViaGRPC(ctx, w, requestPayload.AddTask)
+	case "get_tasks_by_user_id":
+		app.getTasksByUserIDViaGRPC(ctx, w, requestPayload.GetTask)
+	case "update_task":
+		app.updateTaskViaGRPC(ctx, w, requestPayload.UpdateTask)
+	case "delete_task":
+		app.deleteTaskViaGRPC(ctx, w, requestPayload.DeleteTask)
+	default:
+		app.errorJSON(w, errors.New("unknown action"))
+	}
+}
+
+func (app *Config) addTaskViaGRPC(ctx context.Context, w http.ResponseWriter, r AddTaskPayload) {
+	ctx, cancel := context.WithTimeout(ctx, app.timeout(taskService))
+	defer cancel()
+
+	res, err := app.Tasks.CreateTask(ctx, &tasks.CreateTaskRequest{
+		Name:        r.Name,
+		Description: r.Description,
+		UserId:      int64(r.UserID),
+		WorkspaceId: int64(r.WorkspaceID),
+	})
+	if err != nil {
+		app.grpcError(w, taskService, err)
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = "Success added task!"
+	payload.Data = taskFromPB(res.GetTask())
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+func (app *Config) updateTaskViaGRPC(ctx context.Context, w http.ResponseWriter, r UpdateTaskPayload) {
+	ctx, cancel := context.WithTimeout(ctx, app.timeout(taskService))
+	defer cancel()
+
+	res, err := app.Tasks.UpdateTask(ctx, &tasks.UpdateTaskRequest{
+		Task: &tasks.Task{
+			Id:          int64(r.ID),
+			Name:        r.Name,
+			Description: r.Description,
+			UserId:      int64(r.UserID),
+			WorkspaceId: int64(r.WorkspaceID),
+		},
+	})
+	if err != nil {
+		app.grpcError(w, taskService, err)
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = "Success updated task!"
+	payload.Data = taskFromPB(res.GetTask())
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
+
+func (app *Config) deleteTaskViaGRPC(ctx context.Context, w http.ResponseWriter, r DeleteTaskPayload) {
+	ctx, cancel := context.WithTimeout(ctx, app.timeout(taskService))
+	defer cancel()
+
+	_, err := app.Tasks.DeleteTask(ctx, &tasks.DeleteTaskRequest{Id: int64(r.ID), WorkspaceId: int64(r.WorkspaceID)})
+	if err != nil {
+		app.grpcError(w, taskService, err)
+		return
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = "Success deleted task!"
+	payload.Data = TaskJSON{ID: r.ID, WorkspaceID: r.WorkspaceID}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *Config) getTasksByUserIDViaGRPC(ctx context.Context, w http.ResponseWriter, r GetTasksByUserIDPayload) {
+	ctx, cancel := context.WithTimeout(ctx, app.timeout(taskService))
+	defer cancel()
+
+	res, err := app.Tasks.ListTasks(ctx, &tasks.ListTasksRequest{UserId: int64(r.UserID), WorkspaceId: int64(r.WorkspaceID)})
+	if err != nil {
+		app.grpcError(w, taskService, err)
+		return
+	}
+
+	var all []TaskJSON
+	for _, t := range res.GetTasks() {
+		all = append(all, taskFromPB(t))
+	}
+
+	var payload jsonResponse
+	payload.Error = false
+	payload.Message = "Success getting tasks!"
+	payload.Data = all
+
+	app.writeJSON(w, http.StatusCreated, payload)
+}
@@ -1,31 +1,44 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
-	"log"
 	"net/http"
-	"time"
 
 	"github.com/DaffaJatmiko/broker-service/event"
 	"github.com/DaffaJatmiko/broker-service/logs"
+	"github.com/DaffaJatmiko/shared/config"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/serviceauth"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 type RequestPayload struct {
-	Action string `json:"action"`
-	Auth AuthPayload `json:"auth,omitempty"`
-	Log LogPayload `json:"log,omitempty"`
-	Mail MailPayload `json:"mail,omitempty"`
-	Register RegisterPayload `json:"register,omitempty"`
-	AddTask AddTaskPayload `json:"add_task,omitempty"`
-	GetTask GetTasksByUserIDPayload `json:"get_tasks_by_user_id,omitempty"`
-	UpdateTask UpdateTaskPayload `json:"update_task,omitempty"`
-	DeleteTask DeleteTaskPayload `json:"delete_task,omitempty"`
+	Action               string                      `json:"action"`
+	Auth                 AuthPayload                 `json:"auth,omitempty"`
+	AuthTOTP             AuthTOTPPayload             `json:"auth_totp,omitempty"`
+	Log                  LogPayload                  `json:"log,omitempty"`
+	Mail                 MailPayload                 `json:"mail,omitempty"`
+	Register             RegisterPayload             `json:"register,omitempty"`
+	AddTask              AddTaskPayload              `json:"add_task,omitempty"`
+	GetTask              GetTasksByUserIDPayload     `json:"get_tasks_by_user_id,omitempty"`
+	UpdateTask           UpdateTaskPayload           `json:"update_task,omitempty"`
+	DeleteTask           DeleteTaskPayload           `json:"delete_task,omitempty"`
+	Refresh              RefreshPayload              `json:"refresh,omitempty"`
+	Logout               RefreshPayload              `json:"logout,omitempty"`
+	PasswordResetRequest EmailPayload                `json:"password_reset_request,omitempty"`
+	PasswordResetConfirm PasswordResetConfirmPayload `json:"password_reset_confirm,omitempty"`
+	VerifyEmail          VerifyEmailPayload          `json:"verify_email,omitempty"`
+	ResendVerification   EmailPayload                `json:"resend_verification,omitempty"`
+	UpdateProfile        UpdateProfilePayload        `json:"update_profile,omitempty"`
+	ChangePassword       ChangePasswordPayload       `json:"change_password,omitempty"`
+	ChangeEmail          ChangeEmailPayload          `json:"change_email,omitempty"`
+	ConfirmEmailChange   VerifyEmailPayload          `json:"confirm_email_change,omitempty"`
+	ExportStatus         ExportStatusPayload         `json:"export_status,omitempty"`
 }
 
 type AuthPayload struct {
@@ -33,6 +46,29 @@ type AuthPayload struct {
 	Password string `json:"password"`
 }
 
+type AuthTOTPPayload struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type RefreshPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type EmailPayload struct {
+	Email string `json:"email"`
+}
+
+type PasswordResetConfirmPayload struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailPayload struct {
+	Token string `json:"token"`
+}
+
 type LogPayload struct {
 	Name string `json:"name"`
 	Data string `json:"data"`
@@ -52,14 +88,19 @@ type RegisterPayload struct {
 	Password  string `json:"password"`
 }
 
+// The task payloads carry the caller's user ID and the workspace their token is
+// for. Whatever the client sends there is overwritten by HandleTaskService.
+
 type AddTaskPayload struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	UserID      int    `json:"user_id"`
+	WorkspaceID int    `json:"workspace_id"`
 }
 
 type GetTasksByUserIDPayload struct {
-	UserID int `json:"user_id"`
+	UserID      int `json:"user_id"`
+	WorkspaceID int `json:"workspace_id"`
 }
 
 type UpdateTaskPayload struct {
@@ -67,10 +108,12 @@ type UpdateTaskPayload struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	UserID      int    `json:"user_id"`
+	WorkspaceID int    `json:"workspace_id"`
 }
 
 type DeleteTaskPayload struct {
-	ID int `json:"id"`
+	ID          int `json:"id"`
+	WorkspaceID int `json:"workspace_id"`
 }
 
 func (app *Config) Broker(w http.ResponseWriter, r *http.Request) {
@@ -81,7 +124,6 @@ func (app *Config) Broker(w http.ResponseWriter, r *http.Request) {
 
 	_ = app.writeJSON(w, http.StatusOK, payload)
 
-
 }
 
 func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
@@ -93,550 +135,64 @@ func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch requestPayload.Action {
-	case "register":
-		app.register(w, requestPayload.Register)
-	case "auth":
-		app.authenticate(w, requestPayload.Auth)
-	case "log":
-		app.logEventViaRabbit(w, requestPayload.Log)
-	case "mail":
-		app.sendMail(w, requestPayload.Mail)
-	// case "add_task":
-	// 	app.addTask(w, requestPayload.AddTask)
-	// case "get_tasks_by_user_id":
-	// 	app.getTasksByUserID(w, requestPayload.GetTask)
-	// case "update_task":
-	// 	app.updateTask(w, requestPayload.UpdateTask)
-	// case "delete_task":
-	// 	app.deleteTask(w, requestPayload.DeleteTask)
-	default:
-		app.errorJSON(w, errors.New("unknown action"))
-	}
-}
-
-func (app *Config) HandleTaskService(w http.ResponseWriter, r *http.Request) {
-	var requestPayload RequestPayload
-
-	err := app.readJSON(w, r, &requestPayload)
-	if err != nil {
-		app.errorJSON(w, err)
+	// log entries go on the queue rather than to an upstream
+	if requestPayload.Action == "log" {
+		app.logEventViaRabbit(r.Context(), w, requestPayload.Log)
 		return
 	}
 
-	switch requestPayload.Action {
-	case "add_task":
-		app.addTask(w, requestPayload.AddTask)
-	case "get_tasks_by_user_id":
-		app.getTasksByUserID(w, requestPayload.GetTask)
-	case "update_task":
-		app.updateTask(w, requestPayload.UpdateTask)
-	case "delete_task":
-		app.deleteTask(w, requestPayload.DeleteTask)
-	default:
+	// task actions are only served on /handle-task, which checks the caller's token
+	a, ok := actions[requestPayload.Action]
+	if !ok || a.auth == authWorkspace {
 		app.errorJSON(w, errors.New("unknown action"))
-	}
-}
-
-func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
-	// create some json we'll send to auth microservice
-	jsonData, err := json.MarshalIndent(a, "", "\t")
-	if err != nil {
-		log.Println("Error marshalling data", err)
-	}
-
-	// Log the JSON data being sent
-	log.Println("Sending JSON data to auth service:", string(jsonData))
-
-	// call the service
-	request, err := http.NewRequest("POST", "http://authentication-service/authenticate", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error getting response", err)
-		app.errorJSON(w, err)
-		return
-	}
-	defer response.Body.Close()
-
-	// Log the status code from the response
-	log.Println("Received status code:", response.StatusCode)
-
-	// Read the body of the response
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Println("Error reading response body", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	// Log the response body
-	log.Println("Response body:", string(body))
-
-	// make sure we get the correct status code 
-	if response.StatusCode == http.StatusUnauthorized {
-		log.Println("Wrong status code", response.StatusCode)
-		app.errorJSON(w, errors.New("invalid creds"))
-		return
-	} else if response.StatusCode != http.StatusAccepted {
-		log.Println("Wrong status code 2", response.StatusCode)
-		app.errorJSON(w, errors.New("error calling auth service"))
-		return
-	}
-
-	// create a variable we'll read response.Body into
-	var jsonFromService jsonResponse
-
-	// decode the json from the auth service 
-	err = json.NewDecoder(bytes.NewBuffer(body)).Decode(&jsonFromService)
-	if err != nil {
-		log.Println("Error creating decoder", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	if jsonFromService.Error {
-		log.Println("Error from jsonFromService", jsonFromService.Error)
-		app.errorJSON(w, err, http.StatusUnauthorized)
 		return
 	}
 
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Authenticated!"
-	payload.Data = jsonFromService.Data
-
-	app.writeJSON(w, http.StatusAccepted, payload)
+	app.proxy(w, r, a, &requestPayload)
 }
 
+func (app *Config) HandleTaskService(w http.ResponseWriter, r *http.Request) {
+	var requestPayload RequestPayload
 
-func (app *Config) register(w http.ResponseWriter, r RegisterPayload) {
-	jsonData, err := json.MarshalIndent(r, "", "\t")
-	if err != nil {
-		log.Println("Error marshalling data", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Sending JSON data to registration service:", string(jsonData))
-
-	request, err := http.NewRequest("POST", "http://authentication-service/register", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error getting response", err)
-		app.errorJSON(w, err)
-		return
-	}
-	defer response.Body.Close()
-
-	log.Println("Received status code:", response.StatusCode)
-
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Println("Error reading response body", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Response body:", string(body))
-
-	if response.StatusCode != http.StatusCreated {
-		log.Println("Wrong status code", response.StatusCode)
-		app.errorJSON(w, errors.New("error calling registration service"))
-		return
-	}
-
-	var jsonFromService jsonResponse
-
-	err = json.NewDecoder(bytes.NewBuffer(body)).Decode(&jsonFromService)
-	if err != nil {
-		log.Println("Error creating decoder", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	if jsonFromService.Error {
-		log.Println("Error from jsonFromService", jsonFromService.Error)
-		app.errorJSON(w, err, http.StatusUnauthorized)
-		return
-	}
-
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Registered!"
-	payload.Data = jsonFromService.Data
-
-	app.writeJSON(w, http.StatusCreated, payload)
-}
-
-func (app *Config) addTask(w http.ResponseWriter, r AddTaskPayload) {
-	jsonData, err := json.MarshalIndent(r, "", "\t")
-	if err != nil {
-		log.Println("Error marshalling data", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Sending JSON data to task service:", string(jsonData))
-
-	request, err := http.NewRequest("POST", "http://task-service/tasks", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error getting response", err)
-		app.errorJSON(w, err)
-		return
-	}
-	defer response.Body.Close()
-
-	log.Println("Received status code:", response.StatusCode)
-
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Println("Error reading response body", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Response body:", string(body))
-
-	if response.StatusCode != http.StatusCreated {
-		log.Println("Wrong status code", response.StatusCode)
-		app.errorJSON(w, errors.New("error calling task service"))
-		return
-	}
-
-	var jsonFromService jsonResponse
-
-	err = json.NewDecoder(bytes.NewBuffer(body)).Decode(&jsonFromService)
-	if err != nil {
-		log.Println("Error creating decoder", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	if jsonFromService.Error {
-		log.Println("Error from jsonFromService", jsonFromService.Error)
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Success added task!"
-	payload.Data = jsonFromService.Data
-
-	app.writeJSON(w, http.StatusCreated, payload)
-}
-
-func (app *Config) updateTask(w http.ResponseWriter, r UpdateTaskPayload) {
-	jsonData, err := json.MarshalIndent(r, "", "\t")
-	if err != nil {
-		log.Println("Error marshalling data", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Sending JSON data to task service:", string(jsonData))
-
-	request, err := http.NewRequest("PUT", "http://task-service/tasks/update", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error getting response", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	defer response.Body.Close()
-
-	log.Println("Received status code:", response.StatusCode)
-
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Println("Error reading response body", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Response body:", string(body))
-
-	if response.StatusCode != http.StatusAccepted {
-		log.Println("Wrong status code", response.StatusCode)
-		app.errorJSON(w, errors.New("error calling task service"))
-		return
-	}
-
-	var jsonFromService jsonResponse
-
-	err = json.NewDecoder(bytes.NewBuffer(body)).Decode(&jsonFromService)
-	if err != nil {
-		log.Println("Error creating decoder", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	if jsonFromService.Error {
-		log.Println("Error from jsonFromService", jsonFromService.Error)
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Success updated task!"
-	payload.Data = jsonFromService.Data
-
-	app.writeJSON(w, http.StatusCreated, payload)
-
-}
-
-func (app *Config) deleteTask(w http.ResponseWriter, r DeleteTaskPayload) {
-	jsonData, err := json.MarshalIndent(r, "", "\t")
-	if err != nil {
-		log.Println("Error marshalling data", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Sending JSON data to task service:", string(jsonData))
-
-	request, err := http.NewRequest("DELETE", "http://task-service/tasks/delete", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error getting response", err)
-		app.errorJSON(w, err)
-		return
-	}
-	defer response.Body.Close()
-
-	log.Println("Received status code:", response.StatusCode)
-
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Println("Error reading response body", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Response body:", string(body))
-
-	if response.StatusCode != http.StatusAccepted {
-		log.Println("Wrong status code", response.StatusCode)
-		app.errorJSON(w, errors.New("error calling task service"))
-		return
-	}
-
-	var jsonFromService jsonResponse
-
-	err = json.NewDecoder(bytes.NewBuffer(body)).Decode(&jsonFromService)
-	if err != nil {
-		log.Println("Error creating decoder", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	if jsonFromService.Error {
-		log.Println("Error from jsonFromService", jsonFromService.Error)
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Success deleted task!"
-	payload.Data = jsonFromService.Data
-
-	app.writeJSON(w, http.StatusOK, payload)
-}
-
-func (app *Config) getTasksByUserID(w http.ResponseWriter, r GetTasksByUserIDPayload) {
-	jsonData, err := json.MarshalIndent(r, "", "\t")
-	if err != nil {
-		log.Println("Error marshalling data", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Sending JSON data to task service:", string(jsonData))
-
-	request, err := http.NewRequest("GET", "http://task-service/tasks/userId", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error getting response", err)
-		app.errorJSON(w, err)
-		return
-	}
-	defer response.Body.Close()
-
-	log.Println("Received status code:", response.StatusCode)
-
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Println("Error reading response body", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	log.Println("Response body:", string(body))
-
-	if response.StatusCode != http.StatusOK {
-		log.Println("Wrong status code", response.StatusCode)
-		app.errorJSON(w, errors.New("error calling task service"))
-		return
-	}
-
-	var jsonFromService jsonResponse
-
-	err = json.NewDecoder(bytes.NewBuffer(body)).Decode(&jsonFromService)
-	if err != nil {
-		log.Println("Error creating decoder", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	if jsonFromService.Error {
-		log.Println("Error from jsonFromService", jsonFromService.Error)
-		app.errorJSON(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Success getting tasks!"
-	payload.Data = jsonFromService.Data
-
-	app.writeJSON(w, http.StatusCreated, payload)
-}
-
-func (app *Config) logItem(w http.ResponseWriter, entry LogPayload) {
-	jsonData, err := json.MarshalIndent(entry, "", "\t")
+	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		log.Println("Error marshalling data", err)
 		app.errorJSON(w, err)
 		return
 	}
-	
-	logServiceUrl := "http://logger-service/log"
 
-	request, err := http.NewRequest("POST", logServiceUrl, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		app.errorJSON(w, err)
+	// tasks are scoped to the workspace the token was issued for, and callers only
+	// ever act as themselves
+	claims, _ := r.Context().Value(claimsKey).(*Claims)
+	if claims == nil || claims.WorkspaceID == 0 {
+		app.errorJSON(w, errors.New("no workspace selected, please log in again"), http.StatusForbidden)
 		return
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
+	requestPayload.AddTask.WorkspaceID = claims.WorkspaceID
+	requestPayload.GetTask.WorkspaceID = claims.WorkspaceID
+	requestPayload.UpdateTask.WorkspaceID = claims.WorkspaceID
+	requestPayload.DeleteTask.WorkspaceID = claims.WorkspaceID
 
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error getting response", err)
-		app.errorJSON(w, err)
-		return
-	}
-	defer response.Body.Close()
+	requestPayload.AddTask.UserID = claims.UserID
+	requestPayload.GetTask.UserID = claims.UserID
+	requestPayload.UpdateTask.UserID = claims.UserID
 
-	if response.StatusCode != http.StatusAccepted {
-		log.Println("Error calling log service", response.StatusCode, response)
-		app.errorJSON(w, err)
+	if app.TaskTransport == "grpc" {
+		app.handleTaskServiceViaGRPC(r.Context(), w, requestPayload)
 		return
 	}
 
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "logged"
-
-	app.writeJSON(w, http.StatusAccepted, payload)
-}
-
-func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
-
-	jsonData, err := json.MarshalIndent(msg, "", "\t")
-	if err != nil {
-		log.Println("Error marshalling data", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	// call the mail service
-	mailServiceUrl := "http://mail-service/send"
-
-	// post to mail service
-	request, err := http.NewRequest("POST", mailServiceUrl, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	request.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error calling mail service", err)
-		app.errorJSON(w, err)
-		return
-	}
-
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusAccepted {
-		log.Println("Error calling mail service", err)
-		app.errorJSON(w, err)
+	a, ok := actions[requestPayload.Action]
+	if !ok || a.auth != authWorkspace {
+		app.errorJSON(w, errors.New("unknown action"))
 		return
 	}
 
-	// send back json
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Message sent to " + msg.To
-
-	app.writeJSON(w, http.StatusAccepted, payload)
+	app.proxy(w, r, a, &requestPayload)
 }
 
-func (app *Config) logEventViaRabbit(w http.ResponseWriter, l LogPayload) {
-	err := app.pushToQueue(l.Name, l.Data)
+func (app *Config) logEventViaRabbit(ctx context.Context, w http.ResponseWriter, l LogPayload) {
+	err := app.pushToQueue(ctx, l.Name, l.Data)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -649,7 +205,7 @@ func (app *Config) logEventViaRabbit(w http.ResponseWriter, l LogPayload) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) pushToQueue(name, msg string) error {
+func (app *Config) pushToQueue(ctx context.Context, name, msg string) error {
 	emitter, err := event.NewEventEmitter(app.Rabbit)
 	if err != nil {
 		return err
@@ -661,13 +217,28 @@ func (app *Config) pushToQueue(name, msg string) error {
 	}
 
 	j, _ := json.MarshalIndent(&payload, "", "\t")
-	err = emitter.Push(string(j), "log.INFO")
+	err = emitter.Push(ctx, string(j), "log.INFO")
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// logClient sets up a connection to logger-service's gRPC server, kept for the
+// life of the broker in the same way as taskClient.
+func logClient(upstream config.Upstream, serviceAuth *serviceauth.Service) (logs.LogServiceClient, error) {
+	conn, err := grpc.Dial(upstream.GRPC,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(serviceAuth.Credentials(loggerService)),
+		grpc.WithChainUnaryInterceptor(requestid.UnaryClientInterceptor(), metrics.UnaryClientInterceptor(loggerService)),
+		tracing.DialOption())
+	if err != nil {
+		return nil, err
+	}
+
+	return logs.NewLogServiceClient(conn), nil
+}
+
 func (app *Config) LogViaGRPC(w http.ResponseWriter, r *http.Request) {
 	var requestPayload RequestPayload
 
@@ -677,25 +248,17 @@ func (app *Config) LogViaGRPC(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, err := grpc.Dial("logger-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-	defer conn.Close()
-
-	c := logs.NewLogServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(r.Context(), app.timeout(loggerService))
 	defer cancel()
 
-	_, err = c.WriteLog(ctx, &logs.LogRequest{
+	_, err = app.Logs.WriteLog(ctx, &logs.LogRequest{
 		LogEntry: &logs.Log{
 			Name: requestPayload.Log.Name,
 			Data: requestPayload.Log.Data,
 		},
 	})
 	if err != nil {
-		app.errorJSON(w, err)
+		app.grpcError(w, loggerService, err)
 		return
 	}
 
@@ -704,4 +267,4 @@ func (app *Config) LogViaGRPC(w http.ResponseWriter, r *http.Request) {
 	payload.Message = "logged"
 
 	app.writeJSON(w, http.StatusAccepted, payload)
-}
\ No newline at end of file
+}
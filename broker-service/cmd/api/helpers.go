@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 )
 
@@ -67,4 +68,25 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 	payload.Message = err.Error()
 
 	return app.writeJSON(w, statusCode, payload)
-}
\ No newline at end of file
+}
+
+// forwardClient tells the upstream about the client behind r: its address, in
+// X-Forwarded-For, and its User-Agent, which would otherwise be the broker's own.
+func forwardClient(request, r *http.Request) {
+	request.Header.Set("X-Forwarded-For", forwardedFor(r))
+	request.Header.Set("User-Agent", r.UserAgent())
+}
+
+// forwardedFor returns the X-Forwarded-For value to send upstream: any existing
+// chain with the address of the client that called the broker appended.
+func forwardedFor(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+		return prior + ", " + ip
+	}
+	return ip
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DaffaJatmiko/shared/outbound"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	jwksPath = "/.well-known/jwks.json"
+	// jwksTTL is how long a fetched key set is trusted before it is fetched again.
+	jwksTTL = 10 * time.Minute
+	// jwksMinRefresh rate limits refetches triggered by unknown key IDs, so a flood of
+	// tokens with made up kids can't hammer the authentication service.
+	jwksMinRefresh = 30 * time.Second
+)
+
+var errUnknownKey = errors.New("unknown signing key")
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSCache keeps the authentication service's public keys in memory. Keys are
+// refreshed when the cache expires or when a token names a key we haven't seen,
+// which is how a rotated signing key gets picked up.
+type JWKSCache struct {
+	client    *outbound.Client
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func NewJWKSCache(client *outbound.Client) *JWKSCache {
+	return &JWKSCache{
+		client: client,
+		keys:   make(map[string]crypto.PublicKey),
+	}
+}
+
+// Keyfunc is passed to jwt.Parse to look up the key a token was signed with.
+func (c *JWKSCache) Keyfunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodEd25519:
+	default:
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no key id")
+	}
+
+	return c.Get(kid)
+}
+
+// Get returns the public key for kid, refreshing the key set if needed.
+func (c *JWKSCache) Get(kid string) (crypto.PublicKey, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	fresh := time.Since(c.fetchedAt) < jwksTTL
+	recent := time.Since(c.fetchedAt) < jwksMinRefresh
+	c.mu.RUnlock()
+
+	if ok && fresh {
+		return key, nil
+	}
+
+	if !ok && recent {
+		return nil, errUnknownKey
+	}
+
+	if err := c.refresh(); err != nil {
+		// keep serving a stale key rather than locking everyone out while the
+		// authentication service is unavailable
+		if ok {
+			log.Println("Error refreshing JWKS, using cached key", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key, ok = c.keys[kid]
+	if !ok {
+		return nil, errUnknownKey
+	}
+
+	return key, nil
+}
+
+func (c *JWKSCache) refresh() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// another request may have refreshed while we waited for the lock
+	if time.Since(c.fetchedAt) < jwksMinRefresh {
+		return nil
+	}
+
+	response, err := c.client.Get(jwksPath)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching JWKS: status %d", response.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&set)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		pub, err := k.publicKey()
+		if err != nil {
+			log.Println("Skipping JWK", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	log.Printf("Fetched %d keys from JWKS", len(keys))
+
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
@@ -1,42 +1,119 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"strings"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var jwtKey = []byte("jwt-key")
+// accessTokenAudience must match the audience the authentication service puts on
+// access tokens.
+const accessTokenAudience = "task-manager"
 
 type Claims struct {
-	Email string `json:"email"`
+	Email       string   `json:"email"`
+	UserID      int      `json:"user_id"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	SessionID   string   `json:"sid,omitempty"`
+	// WorkspaceID is the workspace the token acts in; task requests are limited to it.
+	WorkspaceID   int    `json:"workspace_id"`
+	WorkspaceRole string `json:"workspace_role"`
 	jwt.StandardClaims
 }
 
-func JWTMiddleware(next http.Handler) http.Handler {
+// HasPermission reports whether the token grants perm.
+func (c *Claims) HasPermission(perm string) bool {
+	for _, p := range c.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey string
+
+const claimsKey contextKey = "claims"
+
+// AccessTokenMiddleware is JWTMiddleware for routes that take personal access
+// tokens as well, which are checked with the authentication service. Only the task
+// routes use it: the services behind the other routes only accept JWTs.
+func (app *Config) AccessTokenMiddleware(next http.Handler) http.Handler {
+	jwtOnly := app.JWTMiddleware(next)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenString := r.Header.Get("Authorization")
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !isAccessToken(tokenString) {
+			jwtOnly.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := app.AccessTokens.Claims(tokenString)
+		if err != nil {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// JWTMiddleware verifies the token against the authentication service's published
+// keys. The broker only ever holds public keys, so it can't mint tokens itself.
+// Verified claims are stored in the request context.
+func (app *Config) JWTMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 		if tokenString == "" {
 			http.Error(w, "Authorization token not found", http.StatusUnauthorized)
 			return
 		}
 
+		if isAccessToken(tokenString) {
+			http.Error(w, "Personal access tokens can only be used for tasks", http.StatusUnauthorized)
+			return
+		}
+
 		claims := &Claims{}
 
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		token, err := jwt.ParseWithClaims(tokenString, claims, app.JWKS.Keyfunc)
 
-		if err != nil{
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		if !token.Valid {
+		if !token.Valid || !claims.VerifyAudience(accessTokenAudience, true) {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		if claims.SessionID != "" && app.Sessions.Revoked(claims.SessionID) {
+			http.Error(w, "Session has been revoked", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
-}
\ No newline at end of file
+}
+
+// RequirePermission only lets through requests whose token grants perm. It must be
+// used after JWTMiddleware.
+func RequirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(claimsKey).(*Claims)
+			if !ok || !claims.HasPermission(perm) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
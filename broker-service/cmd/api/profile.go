@@ -0,0 +1,20 @@
+package main
+
+type UpdateProfilePayload struct {
+	FirstName *string `json:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty"`
+}
+
+type ExportStatusPayload struct {
+	ID int `json:"id"`
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ChangeEmailPayload struct {
+	Password string `json:"password"`
+	NewEmail string `json:"new_email"`
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"net/http"
 
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/cors"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/cors"
 )
 
 func (app *Config) routes() http.Handler {
@@ -15,25 +18,81 @@ func (app *Config) routes() http.Handler {
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", requestid.Header},
+		ExposedHeaders:   []string{"Link", requestid.Header},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(metrics.Endpoint("/metrics"))
+	// every request gets an id that follows it through the other services
+	mux.Use(requestid.Middleware)
+	// and a trace showing where its time went
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
 
 	mux.Post("/", app.Broker)
+	mux.Get("/status", app.UpstreamStatus)
 	mux.Post("/log-grpc", app.LogViaGRPC)
 	mux.Post("/handle", app.HandleSubmission)
+	mux.Post("/oidc/start", app.proxyToAuth)
+	mux.Post("/oidc/callback", app.proxyToAuth)
+	mux.Get("/exports/{token}", app.proxyToAuth)
+	mux.Post("/invitations/inspect", app.proxyToAuth)
+	mux.Post("/invitations/accept", app.proxyToAuth)
+
+	mux.With(app.AccessTokenMiddleware).Route("/handle-task", func(r chi.Router) {
+		r.With(RequirePermission(permTasksWrite)).Post("/", app.HandleTaskService)
+		r.With(RequirePermission(permTasksWrite)).Put("/", app.HandleTaskService)
+		r.With(RequirePermission(permTasksWrite)).Delete("/", app.HandleTaskService)
+		r.With(RequirePermission(permTasksRead)).Get("/", app.HandleTaskService)
+	})
+
+	mux.With(app.JWTMiddleware).Route("/mfa/totp", func(r chi.Router) {
+		r.Post("/enroll", app.proxyToAuth)
+		r.Post("/activate", app.proxyToAuth)
+		r.Post("/disable", app.proxyToAuth)
+	})
+
+	mux.With(app.JWTMiddleware).Route("/sessions", func(r chi.Router) {
+		r.Get("/", app.proxyToAuth)
+		r.Delete("/", app.proxyToAuth)
+		r.Delete("/{id}", app.proxyToAuth)
+	})
+
+	mux.With(app.JWTMiddleware).Route("/tokens", func(r chi.Router) {
+		r.Get("/", app.proxyToAuth)
+		r.Post("/", app.proxyToAuth)
+		r.Delete("/{id}", app.proxyToAuth)
+	})
+
+	// workspace owners can invite without any account-wide permission, so the
+	// authentication service decides who may invite whom
+	mux.With(app.JWTMiddleware).Route("/invitations", func(r chi.Router) {
+		r.Get("/", app.proxyToAuth)
+		r.Post("/", app.proxyToAuth)
+		r.Delete("/{id}", app.proxyToAuth)
+	})
+
+	mux.With(app.JWTMiddleware).Route("/workspaces", func(r chi.Router) {
+		r.Get("/", app.proxyToAuth)
+		r.Post("/", app.proxyToAuth)
+		r.Post("/{id}/switch", app.proxyToAuth)
+		r.Get("/{id}/members", app.proxyToAuth)
+		r.Put("/{id}/members/{userId}", app.proxyToAuth)
+		r.Delete("/{id}/members/{userId}", app.proxyToAuth)
+	})
 
-	mux.With(JWTMiddleware).Route("/handle-task", func(r  chi.Router){
-		r.Post("/", app.HandleTaskService)
-		r.Put("/", app.HandleTaskService)
-		r.Delete("/", app.HandleTaskService)
-		r.Get("/", app.HandleTaskService)
-	}) 
+	mux.With(app.JWTMiddleware).Route("/admin/users", func(r chi.Router) {
+		r.With(RequirePermission(permUsersRead)).Get("/", app.proxyToAuth)
+		r.With(RequirePermission(permUsersWrite)).Put("/{id}", app.proxyToAuth)
+		r.With(RequirePermission(permUsersWrite)).Post("/{id}/deactivate", app.proxyToAuth)
+		r.With(RequirePermission(permUsersWrite)).Post("/{id}/unlock", app.proxyToAuth)
+		r.With(RequirePermission(permUsersDelete)).Delete("/{id}", app.proxyToAuth)
+	})
 
+	mux.With(app.JWTMiddleware, RequirePermission(permUsersDelete)).Get("/admin/deletions", app.proxyToAuth)
 
 	return mux
-}
\ No newline at end of file
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DaffaJatmiko/shared/outbound"
+)
+
+const (
+	revokedSessionsPath = "/sessions/revoked"
+	// revokedSessionsTTL is how long a fetched revocation list is used before it is
+	// fetched again, and so roughly how long a revoked session keeps working.
+	revokedSessionsTTL = 10 * time.Second
+)
+
+// SessionRevocations keeps the list of recently revoked sessions in memory, so
+// JWTMiddleware can reject tokens from a session the user has signed out of
+// without asking the authentication service on every request.
+type SessionRevocations struct {
+	client    *outbound.Client
+	mu        sync.RWMutex
+	revoked   map[string]struct{}
+	fetchedAt time.Time
+}
+
+func NewSessionRevocations(client *outbound.Client) *SessionRevocations {
+	return &SessionRevocations{
+		client:  client,
+		revoked: make(map[string]struct{}),
+	}
+}
+
+// Revoked reports whether the session has been revoked, refreshing the list if it
+// is out of date.
+func (s *SessionRevocations) Revoked(sessionID string) bool {
+	s.mu.RLock()
+	fresh := time.Since(s.fetchedAt) < revokedSessionsTTL
+	s.mu.RUnlock()
+
+	if !fresh {
+		if err := s.refresh(); err != nil {
+			// fall back to the last list we had rather than locking everyone out
+			// while the authentication service is unavailable
+			log.Println("Error refreshing revoked sessions, using cached list", err)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, revoked := s.revoked[sessionID]
+	return revoked
+}
+
+func (s *SessionRevocations) refresh() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another request may have refreshed while we waited for the lock
+	if time.Since(s.fetchedAt) < revokedSessionsTTL {
+		return nil
+	}
+
+	// don't retry on every request while the authentication service is down
+	s.fetchedAt = time.Now()
+
+	response, err := s.client.Get(revokedSessionsPath)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching revoked sessions: unexpected status %d", response.StatusCode)
+	}
+
+	var jsonFromService struct {
+		Data []string `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
+	if err != nil {
+		return err
+	}
+
+	revoked := make(map[string]struct{}, len(jsonFromService.Data))
+	for _, id := range jsonFromService.Data {
+		revoked[id] = struct{}{}
+	}
+	s.revoked = revoked
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/DaffaJatmiko/broker-service/users"
+	"github.com/DaffaJatmiko/shared/config"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/serviceauth"
+	"github.com/DaffaJatmiko/shared/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// userClient sets up a connection to the authentication service's user lookup API.
+// The connection is kept for the life of the broker, and it doesn't wait for the
+// service to be up; the first call does.
+func userClient(upstream config.Upstream, serviceAuth *serviceauth.Service) (users.UserServiceClient, error) {
+	conn, err := grpc.Dial(upstream.GRPC,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(serviceAuth.Credentials(authService)),
+		grpc.WithChainUnaryInterceptor(requestid.UnaryClientInterceptor(), metrics.UnaryClientInterceptor(authService)),
+		tracing.DialOption())
+	if err != nil {
+		return nil, err
+	}
+
+	return users.NewUserServiceClient(conn), nil
+}
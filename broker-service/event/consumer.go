@@ -1,140 +0,0 @@
-package event
-
-import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"log"
-	"net/http"
-
-	amqp "github.com/rabbitmq/amqp091-go"
-)
-
-type Consumer struct {
-	conn *amqp.Connection
-	queueName string
-}
-
-type Payload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
-}
-
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
-	consumer := Consumer{
-		conn: conn,
-	}
-
-	err := consumer.setup()
-	if err != nil {
-		return Consumer{}, err
-	}
-	return consumer, nil
-}
-
-func (consumer *Consumer) setup() error {
-	channel, err := consumer.conn.Channel()
-	if err != nil {
-		return err
-	}
-	return declareExchange(channel)
-}
-
-func (consumer *Consumer) Listen(topics []string) error {
-	ch, err := consumer.conn.Channel()
-	if err != nil {
-		return err
-	}
-	defer ch.Close()
-
-	q, err := declareRandomQueue(ch)
-	if err != nil {
-		return err
-	}
-
-	for _, s := range topics {
-		ch.QueueBind(
-			q.Name,
-			s,
-			"logs_topic",
-			false,
-			nil,
-		)
-		if err != nil {
-			return err
-		}
-	}
-
-	messages, err := ch.Consume(q.Name, "", true, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-
-	forever := make(chan bool)
-	go func() {
-		for d := range messages {
-			var payload Payload
-			_ = json.Unmarshal(d.Body, &payload)
-
-			go handlePayload(payload)
-		}
-	}()
-
-	fmt.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", q.Name)
-	<-forever
-	
-	return nil
-}
-
-
-func handlePayload(payload Payload) {
-	switch payload.Name {
-	case "log", "event":
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}		
-	case "auth":
-		// authenticate
-	default:
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
-	}
-}
-
-func logEvent(entry Payload) error {
-	jsonData, err := json.MarshalIndent(entry, "", "\t")
-	if err != nil {
-		log.Println("Error marshalling data", err)
-		return err
-	}
-	
-	logServiceUrl := "http://logger-service/log"
-
-	request, err := http.NewRequest("POST", logServiceUrl, bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Println("Error creating request", err)
-		return err
-	}
-
-	request.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-
-	response, err := client.Do(request)
-	if err != nil {
-		log.Println("Error getting response", err)
-		return err
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusAccepted {
-		log.Println("Error calling log service", response.StatusCode, response)
-		return err
-	}
-
-	return nil
-}
-
-
@@ -1,8 +1,13 @@
 package event
 
 import (
+	"context"
 	"log"
 
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/tracing"
+
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -20,24 +25,37 @@ func (e *Emitter) SetUp() error {
 	return declareExchange(channel)
 }
 
-func (e *Emitter) Push(event string, severity string) error {
+// Push publishes event with the given severity. The id of the request ctx belongs
+// to goes with it, so the listener can pass it on.
+func (e *Emitter) Push(ctx context.Context, event string, severity string) error {
 	ch, err := e.connection.Channel()
 	if err != nil {
 		return err
 	}
 	defer ch.Close()
 
+	headers := amqp.Table{}
+	if id := requestid.FromContext(ctx); id != "" {
+		headers[requestid.AMQPHeader] = id
+	}
+
+	ctx, span := tracing.StartPublish(ctx, "logs_topic", severity, headers)
+	defer span.End()
+
 	log.Println("Pushing to channel")
-	err = ch.Publish(
+	err = ch.PublishWithContext(ctx,
 		"logs_topic",
 		severity,
 		false,
 		false,
 		amqp.Publishing{
 			ContentType: "text/plain",
+			Headers:     headers,
 			Body:        []byte(event),
 		})
+	metrics.Published("logs_topic", severity, err)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return err
 	}
 	return nil
@@ -53,4 +71,4 @@ func NewEventEmitter(conn *amqp.Connection) (Emitter, error) {
 		return Emitter{}, err
 	}
 	return emitter, nil
-}
\ No newline at end of file
+}
@@ -2,17 +2,24 @@ package event
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/outbound"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 type Consumer struct {
-	conn *amqp.Connection
+	conn      *amqp.Connection
 	queueName string
+	// logger calls the logger service that events are written to.
+	logger *outbound.Client
 }
 
 type Payload struct {
@@ -20,9 +27,10 @@ type Payload struct {
 	Data string `json:"data"`
 }
 
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
+func NewConsumer(conn *amqp.Connection, logger *outbound.Client) (Consumer, error) {
 	consumer := Consumer{
-		conn: conn,
+		conn:   conn,
+		logger: logger,
 	}
 
 	err := consumer.setup()
@@ -76,53 +84,65 @@ func (consumer *Consumer) Listen(topics []string) error {
 			var payload Payload
 			_ = json.Unmarshal(d.Body, &payload)
 
-			go handlePayload(payload)
+			ctx, span := tracing.StartConsume(messageContext(d), q.Name, d)
+			go func() {
+				defer span.End()
+
+				err := consumer.handlePayload(ctx, payload)
+				if err != nil {
+					log.Println(err)
+				}
+				tracing.RecordError(span, err)
+				metrics.Consumed(q.Name, err)
+			}()
 		}
 	}()
 
 	fmt.Printf("Waiting for message [Exchange, Queue] [logs_topic, %s]\n", q.Name)
 	<-forever
-	
+
 	return nil
 }
 
+// messageContext returns a context carrying the id of the request d was published
+// for, if it says.
+func messageContext(d amqp.Delivery) context.Context {
+	ctx := context.Background()
+	if id, ok := d.Headers[requestid.AMQPHeader].(string); ok && requestid.Valid(id) {
+		ctx = requestid.NewContext(ctx, id)
+	}
+	return ctx
+}
 
-func handlePayload(payload Payload) {
+func (consumer *Consumer) handlePayload(ctx context.Context, payload Payload) error {
 	switch payload.Name {
 	case "log", "event":
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}		
+		return consumer.logEvent(ctx, payload)
 	case "auth":
 		// authenticate
+		return nil
 	default:
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
+		return consumer.logEvent(ctx, payload)
 	}
 }
 
-func logEvent(entry Payload) error {
+func (consumer *Consumer) logEvent(ctx context.Context, entry Payload) error {
 	jsonData, err := json.MarshalIndent(entry, "", "\t")
 	if err != nil {
 		log.Println("Error marshalling data", err)
 		return err
 	}
-	
-	logServiceUrl := "http://logger-service/log"
 
-	request, err := http.NewRequest("POST", logServiceUrl, bytes.NewBuffer(jsonData))
+	logServiceUrl := consumer.logger.Endpoint("/log")
+
+	request, err := http.NewRequestWithContext(ctx, "POST", logServiceUrl, bytes.NewBuffer(jsonData))
 	if err != nil {
 		log.Println("Error creating request", err)
 		return err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-
-	response, err := client.Do(request)
+	response, err := consumer.logger.Do(request)
 	if err != nil {
 		log.Println("Error getting response", err)
 		return err
@@ -131,10 +151,8 @@ func logEvent(entry Payload) error {
 
 	if response.StatusCode != http.StatusAccepted {
 		log.Println("Error calling log service", response.StatusCode, response)
-		return err
+		return fmt.Errorf("logger service returned %d", response.StatusCode)
 	}
 
 	return nil
 }
-
-
@@ -6,13 +6,13 @@ import (
 
 func declareExchange(ch *amqp.Channel) error {
 	return ch.ExchangeDeclare(
-		"logs_topic",  // name
-		"topic",     	 // type
-		true,					 // durable
-		false,				 // auto-deleted
-		false, 				 // internal
-		false, 				 // no-wait
-		nil, 				 // arguments
+		"logs_topic", // name
+		"topic",      // type
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
 	)
 }
 
@@ -25,4 +25,4 @@ func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
 		false, // no-wait
 		nil,   // arguments
 	)
-}
\ No newline at end of file
+}
@@ -0,0 +1,153 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// usersExchange carries events about user accounts from the authentication service.
+	usersExchange         = "users_topic"
+	userDeleted           = "user.deleted"
+	userDeletionCompleted = "user.deletion.completed"
+	// deletionsQueue collects user deleted events for the listener service.
+	deletionsQueue = "listener-service.user.deleted"
+	// deletionStep is the step of an account deletion we do on the logger's behalf.
+	deletionStep = "logs"
+)
+
+type userDeletedEvent struct {
+	DeletionID int    `json:"deletion_id"`
+	UserID     int    `json:"user_id"`
+	Email      string `json:"email"`
+}
+
+type deletionCompletedEvent struct {
+	DeletionID int    `json:"deletion_id"`
+	UserID     int    `json:"user_id"`
+	Service    string `json:"service"`
+}
+
+// ListenForDeletions erases the logs of deleted users and tells the authentication
+// service when that is done. Failures aren't retried here; the authentication
+// service keeps publishing the deletion until we report it done.
+func (consumer *Consumer) ListenForDeletions() error {
+	ch, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	err = ch.ExchangeDeclare(usersExchange, "topic", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(deletionsQueue, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(q.Name, userDeleted, usersExchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	messages, err := ch.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Waiting for message [Exchange, Queue] [%s, %s]\n", usersExchange, q.Name)
+
+	for d := range messages {
+		consumer.handleDeletion(ch, q.Name, d)
+	}
+
+	return nil
+}
+
+// handleDeletion handles one message from the deletions queue, within a span
+// continuing the trace the authentication service published it with.
+func (consumer *Consumer) handleDeletion(ch *amqp.Channel, queue string, d amqp.Delivery) {
+	ctx, span := tracing.StartConsume(context.Background(), queue, d)
+	defer span.End()
+
+	var payload userDeletedEvent
+	if err := json.Unmarshal(d.Body, &payload); err != nil {
+		log.Println("Dropping malformed user deleted event", err)
+		tracing.RecordError(span, err)
+		metrics.Consumed(queue, err)
+		_ = d.Ack(false)
+		return
+	}
+
+	if err := consumer.eraseLogs(ctx, payload.UserID); err != nil {
+		log.Printf("Error erasing logs of user %d: %v", payload.UserID, err)
+		tracing.RecordError(span, err)
+		metrics.Consumed(queue, err)
+		_ = d.Nack(false, false)
+		return
+	}
+
+	body, _ := json.Marshal(deletionCompletedEvent{
+		DeletionID: payload.DeletionID,
+		UserID:     payload.UserID,
+		Service:    deletionStep,
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	headers := amqp.Table{}
+	ctx, publishSpan := tracing.StartPublish(ctx, usersExchange, userDeletionCompleted, headers)
+	err := ch.PublishWithContext(ctx, usersExchange, userDeletionCompleted, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Headers:      headers,
+		Body:         body,
+	})
+	tracing.RecordError(publishSpan, err)
+	publishSpan.End()
+	metrics.Published(usersExchange, userDeletionCompleted, err)
+	if err != nil {
+		log.Println("Error reporting deletion completed", err)
+	}
+
+	metrics.Consumed(queue, nil)
+	_ = d.Ack(false)
+}
+
+func (consumer *Consumer) eraseLogs(ctx context.Context, userID int) error {
+	jsonData, err := json.Marshal(map[string]int{"user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", consumer.logger.Endpoint("/erase"), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	response, err := consumer.logger.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("logger service returned %d", response.StatusCode)
+	}
+
+	return nil
+}
@@ -8,7 +8,12 @@ import (
 
 	"github.com/DaffaJatmiko/logger-service/data"
 	"github.com/DaffaJatmiko/logger-service/logs"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type LogServer struct {
@@ -21,14 +26,16 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 
 	// write the log
 	logEntry := data.LogEntry{
-		Name: input.Name,
-		Data: input.Data,
+		Name:      input.Name,
+		Data:      input.Data,
+		RequestID: requestid.FromContext(ctx),
 	}
 
 	err := l.Models.LogEntry.Insert(logEntry)
 	if err != nil {
+		// Insert has logged why; callers don't need the database's own words
 		res := &logs.LogResponse{Result: "Failed"}
-		return res, err
+		return res, status.Error(codes.Internal, "internal error")
 	}
 
 	// return response
@@ -43,13 +50,17 @@ func (app *Config) gRPCListen() {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(
+		tracing.ServerOption(),
+		grpc.ChainUnaryInterceptor(requestid.UnaryServerInterceptor(), metrics.UnaryServerInterceptor(), app.ServiceAuth.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(requestid.StreamServerInterceptor(), metrics.StreamServerInterceptor(), app.ServiceAuth.StreamInterceptor()),
+	)
 
 	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models})
 
 	log.Printf("gRPC server listening on %s", gRpcPort)
-	
+
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
-}
\ No newline at end of file
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/DaffaJatmiko/logger-service/data"
+	"github.com/DaffaJatmiko/shared/requestid"
 )
 
 type JSONPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+	Name   string `json:"name"`
+	Data   string `json:"data"`
+	UserID int    `json:"user_id,omitempty"`
 }
 
 func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
@@ -16,10 +20,12 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	var requestPayload JSONPayload
 	_ = app.readJSON(w, r, &requestPayload)
 
-	// insert data 
+	// insert data
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Name:      requestPayload.Name,
+		Data:      requestPayload.Data,
+		RequestID: requestid.FromContext(r.Context()),
+		UserID:    requestPayload.UserID,
 	}
 
 	err := app.Models.LogEntry.Insert(event)
@@ -34,4 +40,85 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	app.writeJSON(w, http.StatusAccepted, resp)
-}
\ No newline at end of file
+}
+
+// SearchLogs returns the log entries about a user, for exporting the user's data.
+func (app *Config) SearchLogs(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		UserID int `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil || requestPayload.UserID <= 0 {
+		app.errorJSON(w, errors.New("user_id is required"), http.StatusBadRequest)
+		return
+	}
+
+	logs, err := app.Models.LogEntry.ForUser(requestPayload.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("found %d entries", len(logs)),
+		Data:    logs,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// TraceLogs returns the log entries written for one request, oldest first.
+func (app *Config) TraceLogs(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RequestID string `json:"request_id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil || !requestid.Valid(requestPayload.RequestID) {
+		app.errorJSON(w, errors.New("request_id is required"), http.StatusBadRequest)
+		return
+	}
+
+	logs, err := app.Models.LogEntry.ForRequest(requestPayload.RequestID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("found %d entries", len(logs)),
+		Data:    logs,
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// EraseLogs deletes the log entries about a user. The listener service calls it when
+// an account is deleted.
+func (app *Config) EraseLogs(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		UserID int `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil || requestPayload.UserID <= 0 {
+		app.errorJSON(w, errors.New("user_id is required"), http.StatusBadRequest)
+		return
+	}
+
+	n, err := app.Models.LogEntry.DeleteForUser(requestPayload.UserID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("erased %d entries", n),
+	}
+
+	app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -8,6 +8,9 @@ import (
 	"time"
 
 	"github.com/DaffaJatmiko/logger-service/data"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/serviceauth"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -22,10 +25,29 @@ const (
 var client *mongo.Client
 
 type Config struct {
-	Models data.Models
+	Models      data.Models
+	ServiceAuth *serviceauth.Service
 }
 
 func main() {
+	// every other backend service writes logs; the authentication service also
+	// searches them for exports and the listener erases them for deleted accounts
+	serviceAuth, err := serviceauth.FromEnv("logger-service",
+		"broker-service", "authentication-service", "task-service", "listener-service")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	shutdownTracing, err := tracing.Setup("logger-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if err := metrics.Setup("logger-service", mongoCollectors()...); err != nil {
+		log.Panic(err)
+	}
+
 	// connect to MongoDB
 	mongoClient, err := connectToMongo()
 	if err != nil {
@@ -38,14 +60,20 @@ func main() {
 	defer cancel()
 
 	// close the connection
-	defer func(){
+	defer func() {
 		if err = client.Disconnect(ctx); err != nil {
 			panic(err)
 		}
 	}()
 
 	app := Config{
-		Models: data.New(client),
+		Models:      data.New(client),
+		ServiceAuth: serviceAuth,
+	}
+
+	// entries are looked up by request id to follow a request across services
+	if err := app.Models.LogEntry.CreateIndexes(); err != nil {
+		log.Println("Error creating log indexes:", err)
 	}
 
 	go app.gRPCListen()
@@ -60,7 +88,7 @@ func main() {
 	log.Println("Starting web server on port", webPort)
 	err = srv.ListenAndServe()
 	if err != nil {
-			log.Panic("Web server failed to start: ", err)
+		log.Panic("Web server failed to start: ", err)
 	}
 }
 
@@ -83,7 +111,9 @@ func connectToMongo() (*mongo.Client, error) {
 		Username: "admin",
 		Password: "password",
 	})
-	
+	clientOptions.SetMonitor(mongoCommandMonitor())
+	clientOptions.SetPoolMonitor(mongoPoolMonitor())
+
 	// TODO: Connect to MongoDB
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
@@ -92,4 +122,4 @@ func connectToMongo() (*mongo.Client, error) {
 	}
 	log.Println("Connected to MongoDB!")
 	return client, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/DaffaJatmiko/shared/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	mongoOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mongo_open_connections",
+		Help: "Connections to MongoDB in the pool, in use or idle.",
+	})
+
+	mongoInUse = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mongo_in_use_connections",
+		Help: "Connections to MongoDB checked out of the pool.",
+	})
+
+	mongoCheckoutFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mongo_checkout_failures_total",
+		Help: "Times a connection to MongoDB couldn't be checked out of the pool.",
+	})
+)
+
+// mongoCollectors are the MongoDB connection pool metrics, registered alongside
+// the shared ones by metrics.Setup.
+func mongoCollectors() []prometheus.Collector {
+	return []prometheus.Collector{mongoOpen, mongoInUse, mongoCheckoutFailures}
+}
+
+// mongoPoolMonitor returns a monitor that keeps the MongoDB connection pool metrics.
+func mongoPoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.ConnectionCreated:
+				mongoOpen.Inc()
+			case event.ConnectionClosed:
+				mongoOpen.Dec()
+			case event.GetSucceeded:
+				mongoInUse.Inc()
+			case event.ConnectionReturned:
+				mongoInUse.Dec()
+			case event.GetFailed:
+				mongoCheckoutFailures.Inc()
+			}
+		},
+	}
+}
+
+// mongoCommandMonitor returns a monitor that records a span for each command sent to
+// MongoDB, as a child of the span in the context the operation was run with. The
+// command itself isn't recorded, since it holds the log entries being written.
+func mongoCommandMonitor() *event.CommandMonitor {
+	m := &commandMonitor{spans: make(map[commandKey]trace.Span)}
+
+	return &event.CommandMonitor{
+		Started:   m.started,
+		Succeeded: m.succeeded,
+		Failed:    m.failed,
+	}
+}
+
+// commandKey picks out one command; request ids are only unique per connection.
+type commandKey struct {
+	connection string
+	request    int64
+}
+
+type commandMonitor struct {
+	mu    sync.Mutex
+	spans map[commandKey]trace.Span
+}
+
+func (m *commandMonitor) started(ctx context.Context, e *event.CommandStartedEvent) {
+	name := "mongo " + e.CommandName
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemMongoDB,
+		semconv.DBName(e.DatabaseName),
+		semconv.DBOperation(e.CommandName),
+	}
+	// most commands name the collection they work on as their first value
+	if collection, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok {
+		name += " " + collection
+		attrs = append(attrs, semconv.DBMongoDBCollection(collection))
+	}
+
+	_, span := tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+
+	m.mu.Lock()
+	m.spans[commandKey{e.ConnectionID, e.RequestID}] = span
+	m.mu.Unlock()
+}
+
+func (m *commandMonitor) succeeded(_ context.Context, e *event.CommandSucceededEvent) {
+	if span := m.finish(e.CommandFinishedEvent); span != nil {
+		span.End()
+	}
+}
+
+func (m *commandMonitor) failed(_ context.Context, e *event.CommandFailedEvent) {
+	if span := m.finish(e.CommandFinishedEvent); span != nil {
+		span.SetStatus(codes.Error, e.Failure)
+		span.End()
+	}
+}
+
+func (m *commandMonitor) finish(e event.CommandFinishedEvent) trace.Span {
+	key := commandKey{e.ConnectionID, e.RequestID}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	span := m.spans[key]
+	delete(m.spans, key)
+	return span
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"net/http"
 
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/cors"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/cors"
 )
 
 func (app *Config) routes() http.Handler {
@@ -22,8 +25,16 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(metrics.Endpoint("/metrics"))
+	mux.Use(requestid.Middleware)
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+	mux.Use(app.ServiceAuth.Middleware)
 
 	mux.Post("/log", app.WriteLog)
+	mux.Post("/search", app.SearchLogs)
+	mux.Post("/trace", app.TraceLogs)
+	mux.Post("/erase", app.EraseLogs)
 
 	return mux
-}
\ No newline at end of file
+}
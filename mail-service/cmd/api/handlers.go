@@ -18,23 +18,23 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	msg := Message {
-		From: requestPayload.From,
-		To: requestPayload.To,
+	msg := Message{
+		From:    requestPayload.From,
+		To:      requestPayload.To,
 		Subject: requestPayload.Subject,
-		Data: requestPayload.Message,
+		Data:    requestPayload.Message,
 	}
 
-	err = app.Mailer.SendSMTPMessage(msg)
+	err = app.Mailer.SendSMTPMessage(r.Context(), msg)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	payload := jsonResponse {
-		Error: false,
+	payload := jsonResponse{
+		Error:   false,
 		Message: "sent to " + requestPayload.To,
 	}
 	app.writeJSON(w, http.StatusAccepted, payload)
 
-}
\ No newline at end of file
+}
@@ -2,13 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"text/template"
 	"time"
 
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/tracing"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var mailSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mail_sent_total",
+	Help: "Emails sent, by outcome.",
+}, []string{"outcome"})
+
 type Mail struct {
 	Domain      string
 	Host        string
@@ -30,7 +41,11 @@ type Message struct {
 	DataMap     map[string]any
 }
 
-func (m *Mail) SendSMTPMessage(msg Message) error {
+// SendSMTPMessage renders msg and sends it, recording a span for the time spent
+// talking to the SMTP server. Every email is counted, whether it went or not.
+func (m *Mail) SendSMTPMessage(ctx context.Context, msg Message) (err error) {
+	defer func() { mailSent.WithLabelValues(metrics.Outcome(err)).Inc() }()
+
 	if msg.From == "" {
 		msg.From = m.FromAddress
 	}
@@ -55,6 +70,14 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 		return err
 	}
 
+	_, span := tracing.Tracer().Start(ctx, "smtp send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.ServerAddress(m.Host), semconv.ServerPort(m.Port)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	server := mail.NewSMTPClient()
 	server.Host = m.Host
 	server.Port = m.Port
@@ -117,7 +140,7 @@ func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
 func (m *Mail) inlineCSS(formattedMessage string) (string, error) {
 	options := premailer.Options{
 		RemoveClasses:     false,
-		CssToAttributes: false,
+		CssToAttributes:   false,
 		KeepBangImportant: true,
 	}
 
@@ -165,4 +188,3 @@ func (m *Mail) getEncryption(s string) mail.Encryption {
 		return mail.EncryptionNone
 	}
 }
-
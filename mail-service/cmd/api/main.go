@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/tracing"
 )
 
 type Config struct {
@@ -15,6 +19,16 @@ type Config struct {
 const webPort = "80"
 
 func main() {
+	shutdownTracing, err := tracing.Setup("mail-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if err := metrics.Setup("mail-service", mailSent); err != nil {
+		log.Panic(err)
+	}
+
 	app := Config{
 		Mailer: createMail(),
 	}
@@ -26,7 +40,7 @@ func main() {
 		Handler: app.routes(),
 	}
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if err != nil {
 		log.Panic(err)
 	}
@@ -35,7 +49,7 @@ func main() {
 func createMail() Mail {
 	port, _ := strconv.Atoi(os.Getenv("MAIL_PORT"))
 
-	m := Mail {
+	m := Mail{
 		Domain:      os.Getenv("MAIL_DOMAIN"),
 		Host:        os.Getenv("MAIL_HOST"),
 		Port:        port,
@@ -47,4 +61,4 @@ func createMail() Mail {
 	}
 
 	return m
-}
\ No newline at end of file
+}
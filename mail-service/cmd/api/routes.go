@@ -3,9 +3,11 @@ package main
 import (
 	"net/http"
 
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/cors"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-chi/cors"
 )
 
 func (app *Config) routes() http.Handler {
@@ -22,8 +24,11 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(metrics.Endpoint("/metrics"))
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
 
 	mux.Post("/send", app.SendMail)
 
 	return mux
-}
\ No newline at end of file
+}
@@ -0,0 +1,324 @@
+// Package config says where the services this one talks to can be found.
+//
+// Each program starts from defaults that work under docker-compose. A JSON file,
+// named by the CONFIG_FILE environment variable, can change any of them, and
+// environment variables override the file:
+//
+//	{
+//	  "upstreams": {
+//	    "task-service": {"url": "http://localhost:8082", "grpc": "localhost:50001", "timeout": "5s"}
+//	  },
+//	  "rabbitmq": {"url": "amqp://localhost:5672", "username": "guest", "password": "guest"}
+//	}
+//
+// For an upstream called task-service the variables are TASK_SERVICE_URL,
+// TASK_SERVICE_GRPC, TASK_SERVICE_TIMEOUT, TASK_SERVICE_ATTEMPTS,
+// TASK_SERVICE_MAX_CONCURRENT, TASK_SERVICE_BREAKER_THRESHOLD and
+// TASK_SERVICE_BREAKER_COOLDOWN. RabbitMQ is set with RABBITMQ_URL,
+// RABBITMQ_USERNAME and RABBITMQ_PASSWORD.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Upstream is one service we call.
+type Upstream struct {
+	// URL is the base URL of the service's HTTP API, such as http://task-service.
+	URL string `json:"url,omitempty"`
+	// GRPC is the host:port of the service's gRPC server.
+	GRPC string `json:"grpc,omitempty"`
+	// Timeout bounds each call to the service.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// The rest tune how hard the outbound client tries. Zero means its default.
+
+	// Attempts is how many times an idempotent call is tried before giving up;
+	// 1 turns retries off.
+	Attempts int `json:"attempts,omitempty"`
+	// MaxConcurrent caps the calls to the service in flight at once.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// BreakerThreshold is how many failures in a row stop calls to the service.
+	BreakerThreshold int `json:"breaker_threshold,omitempty"`
+	// BreakerCooldown is how long calls stay stopped before one is let through to
+	// see whether the service is back.
+	BreakerCooldown Duration `json:"breaker_cooldown,omitempty"`
+}
+
+// Endpoint returns the URL of path on the upstream's HTTP API.
+func (u Upstream) Endpoint(path string) string {
+	return strings.TrimSuffix(u.URL, "/") + path
+}
+
+// RabbitMQ is where the message broker is and how to log in to it.
+type RabbitMQ struct {
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// DialURL returns URL with Username and Password filled in. Credentials already in
+// URL are kept unless Username is set.
+func (r RabbitMQ) DialURL() string {
+	u, err := url.Parse(r.URL)
+	if err != nil || r.Username == "" {
+		return r.URL
+	}
+
+	u.User = url.UserPassword(r.Username, r.Password)
+	return u.String()
+}
+
+type Config struct {
+	Upstreams map[string]Upstream `json:"upstreams"`
+	RabbitMQ  RabbitMQ            `json:"rabbitmq"`
+}
+
+// Upstream returns the settings for the upstream called name. Every upstream in
+// the defaults passed to Load is present.
+func (c *Config) Upstream(name string) Upstream {
+	return c.Upstreams[name]
+}
+
+// Load starts from defaults, applies the file at path if path isn't empty, then
+// the environment, and checks the result. Only upstreams named in defaults can be
+// configured; the file can't add new ones.
+func Load(path string, defaults Config) (*Config, error) {
+	cfg := &Config{
+		Upstreams: make(map[string]Upstream),
+		RabbitMQ:  defaults.RabbitMQ,
+	}
+	for name, u := range defaults.Upstreams {
+		cfg.Upstreams[name] = u
+	}
+
+	if path != "" {
+		err := cfg.applyFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.applyEnv()
+
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) applyFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var file Config
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, u := range file.Upstreams {
+		current, ok := c.Upstreams[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown upstream %q", path, name)
+		}
+		if u.URL != "" {
+			current.URL = u.URL
+		}
+		if u.GRPC != "" {
+			current.GRPC = u.GRPC
+		}
+		if u.Timeout.Duration != 0 {
+			current.Timeout = u.Timeout
+		}
+		if u.Attempts != 0 {
+			current.Attempts = u.Attempts
+		}
+		if u.MaxConcurrent != 0 {
+			current.MaxConcurrent = u.MaxConcurrent
+		}
+		if u.BreakerThreshold != 0 {
+			current.BreakerThreshold = u.BreakerThreshold
+		}
+		if u.BreakerCooldown.Duration != 0 {
+			current.BreakerCooldown = u.BreakerCooldown
+		}
+		c.Upstreams[name] = current
+	}
+
+	c.RabbitMQ.merge(file.RabbitMQ)
+
+	return nil
+}
+
+func (c *Config) applyEnv() {
+	for name, u := range c.Upstreams {
+		prefix := envPrefix(name)
+
+		if v := os.Getenv(prefix + "_URL"); v != "" {
+			u.URL = v
+		}
+		if v := os.Getenv(prefix + "_GRPC"); v != "" {
+			u.GRPC = v
+		}
+		// bad values are reported by Validate
+		if v := os.Getenv(prefix + "_TIMEOUT"); v != "" {
+			u.Timeout = envDuration(v)
+		}
+		if v := os.Getenv(prefix + "_ATTEMPTS"); v != "" {
+			u.Attempts = envInt(v)
+		}
+		if v := os.Getenv(prefix + "_MAX_CONCURRENT"); v != "" {
+			u.MaxConcurrent = envInt(v)
+		}
+		if v := os.Getenv(prefix + "_BREAKER_THRESHOLD"); v != "" {
+			u.BreakerThreshold = envInt(v)
+		}
+		if v := os.Getenv(prefix + "_BREAKER_COOLDOWN"); v != "" {
+			u.BreakerCooldown = envDuration(v)
+		}
+
+		c.Upstreams[name] = u
+	}
+
+	c.RabbitMQ.merge(RabbitMQ{
+		URL:      os.Getenv("RABBITMQ_URL"),
+		Username: os.Getenv("RABBITMQ_USERNAME"),
+		Password: os.Getenv("RABBITMQ_PASSWORD"),
+	})
+}
+
+// envDuration parses v, returning a negative duration, which Validate rejects,
+// if it can't.
+func envDuration(v string) Duration {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return Duration{-1}
+	}
+	return Duration{d}
+}
+
+// envInt parses v, returning -1, which Validate rejects, if it can't.
+func envInt(v string) int {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func (r *RabbitMQ) merge(other RabbitMQ) {
+	if other.URL != "" {
+		r.URL = other.URL
+	}
+	if other.Username != "" {
+		r.Username = other.Username
+	}
+	if other.Password != "" {
+		r.Password = other.Password
+	}
+}
+
+// Validate reports every setting that can't work, so they can all be fixed at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	names := make([]string, 0, len(c.Upstreams))
+	for name := range c.Upstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		u := c.Upstreams[name]
+
+		if u.URL == "" && u.GRPC == "" {
+			errs = append(errs, fmt.Errorf("%s: no url or grpc address", name))
+		}
+		if u.URL != "" {
+			parsed, err := url.Parse(u.URL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				errs = append(errs, fmt.Errorf("%s: url %q is not an http or https URL", name, u.URL))
+			}
+		}
+		if u.GRPC != "" {
+			host, port, err := net.SplitHostPort(u.GRPC)
+			if err != nil || host == "" || port == "" {
+				errs = append(errs, fmt.Errorf("%s: grpc address %q is not host:port", name, u.GRPC))
+			}
+		}
+		if u.Timeout.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("%s: timeout must be a positive duration such as 5s", name))
+		}
+		if u.Attempts < 0 {
+			errs = append(errs, fmt.Errorf("%s: attempts must be a positive number", name))
+		}
+		if u.MaxConcurrent < 0 {
+			errs = append(errs, fmt.Errorf("%s: max_concurrent must be a positive number", name))
+		}
+		if u.BreakerThreshold < 0 {
+			errs = append(errs, fmt.Errorf("%s: breaker_threshold must be a positive number", name))
+		}
+		if u.BreakerCooldown.Duration < 0 {
+			errs = append(errs, fmt.Errorf("%s: breaker_cooldown must be a positive duration such as 30s", name))
+		}
+	}
+
+	if c.RabbitMQ.URL != "" {
+		parsed, err := url.Parse(c.RabbitMQ.URL)
+		if err != nil || (parsed.Scheme != "amqp" && parsed.Scheme != "amqps") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("rabbitmq: url %q is not an amqp or amqps URL", c.RabbitMQ.URL))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// envPrefix turns an upstream name such as task-service into TASK_SERVICE.
+func envPrefix(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// Duration is a time.Duration written as a string such as "5s" in JSON.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = parsed
+	return nil
+}
@@ -0,0 +1,123 @@
+// Package metrics keeps the service's Prometheus metrics and serves them for
+// scraping. Every service uses the same metric names and labels for the same
+// things, and every metric carries a service label naming the service it came
+// from.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of the work counted by metrics with an outcome label.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+var registry = prometheus.NewRegistry()
+
+// own holds the metrics declared in this package; Setup registers them.
+var own []prometheus.Collector
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+func init() {
+	own = append(own, httpRequests, httpDuration)
+}
+
+// Setup registers the package's metrics, the Go runtime and process metrics and
+// any extra collectors, all labelled with the name of the service.
+func Setup(service string, extra ...prometheus.Collector) error {
+	r := prometheus.WrapRegistererWith(prometheus.Labels{"service": service}, registry)
+
+	all := []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	}
+	all = append(all, own...)
+	all = append(all, extra...)
+
+	for _, c := range all {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Endpoint serves the metrics on GET requests for path and passes every other
+// request on, in the way middleware.Heartbeat serves /ping. Used ahead of any
+// middleware that checks callers, it lets Prometheus scrape without a token.
+func Endpoint(path string) func(http.Handler) http.Handler {
+	handler := Handler()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.URL.Path == path {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware counts and times requests by the route the router matched them to,
+// so requests for /tasks/1 and /tasks/2 are counted together. Requests that
+// matched no route are counted under "unmatched".
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Outcome is Success if err is nil and Failure otherwise.
+func Outcome(err error) string {
+	if err != nil {
+		return Failure
+	}
+	return Success
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	rabbitPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rabbitmq_published_total",
+		Help: "Messages published to RabbitMQ, by exchange, routing key and outcome.",
+	}, []string{"exchange", "routing_key", "outcome"})
+
+	rabbitConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rabbitmq_consumed_total",
+		Help: "Messages taken from RabbitMQ and handled, by queue and outcome.",
+	}, []string{"queue", "outcome"})
+)
+
+func init() {
+	own = append(own, rabbitPublished, rabbitConsumed)
+}
+
+// Published records publishing a message to exchange with routing key key, which
+// failed if err isn't nil.
+func Published(exchange, key string, err error) {
+	rabbitPublished.WithLabelValues(exchange, key, Outcome(err)).Inc()
+}
+
+// Consumed records handling a message taken from queue, which failed if err isn't
+// nil.
+func Consumed(queue string, err error) {
+	rabbitConsumed.WithLabelValues(queue, Outcome(err)).Inc()
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes of calls to upstreams, beyond Success and Failure. A call fails when
+// the upstream can't be reached or answers with a server error, the same as
+// counts against its circuit breaker.
+const (
+	// CircuitOpen is a call turned away because the upstream's breaker is open.
+	CircuitOpen = "circuit_open"
+	// BulkheadFull is a call turned away because too many were in flight.
+	BulkheadFull = "bulkhead_full"
+)
+
+var (
+	upstreamCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "upstream_requests_total",
+		Help: "Calls made to other services, by upstream and outcome.",
+	}, []string{"upstream", "outcome"})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "upstream_request_duration_seconds",
+		Help:    "Time taken by calls to other services, retries included, by upstream and outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream", "outcome"})
+
+	upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "upstream_retries_total",
+		Help: "Calls to other services tried again after failing, by upstream.",
+	}, []string{"upstream"})
+)
+
+func init() {
+	own = append(own, upstreamCalls, upstreamDuration, upstreamRetries)
+}
+
+// UpstreamCall records a call to upstream that ended with outcome after took.
+func UpstreamCall(upstream, outcome string, took time.Duration) {
+	upstreamCalls.WithLabelValues(upstream, outcome).Inc()
+	upstreamDuration.WithLabelValues(upstream, outcome).Observe(took.Seconds())
+}
+
+// UpstreamRetry records that a call to upstream is being tried again.
+func UpstreamRetry(upstream string) {
+	upstreamRetries.WithLabelValues(upstream).Inc()
+}
@@ -0,0 +1,114 @@
+package outbound
+
+import (
+	"sync"
+	"time"
+)
+
+// State is where a circuit breaker is in its cycle.
+type State string
+
+const (
+	// Closed lets every call through.
+	Closed State = "closed"
+	// Open turns every call away until the cooldown is over.
+	Open State = "open"
+	// HalfOpen lets a single probe through; its result closes or reopens the breaker.
+	HalfOpen State = "half-open"
+)
+
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a call may go ahead, and whether it is the probe of a
+// half-open breaker.
+func (b *breaker) allow() (probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false, false
+		}
+		b.state = HalfOpen
+		b.probing = true
+		return true, true
+	case HalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// record counts the result of a call allow let through.
+func (b *breaker) record(probe, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen {
+		// only the probe decides; calls that started before the breaker opened don't
+		if !probe {
+			return
+		}
+		b.probing = false
+		if success {
+			b.state = Closed
+			b.failures = 0
+		} else {
+			b.trip()
+		}
+		return
+	}
+
+	if success {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == Closed && b.failures >= b.threshold {
+		b.trip()
+	}
+}
+
+// release gives up a call allow let through without making it.
+func (b *breaker) release(probe bool) {
+	if !probe {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *breaker) trip() {
+	b.state = Open
+	b.openedAt = time.Now()
+}
+
+func (b *breaker) status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := Status{State: b.state, Failures: b.failures}
+	if b.state == Open {
+		retryAt := b.openedAt.Add(b.cooldown)
+		s.RetryAt = &retryAt
+	}
+	return s
+}
@@ -0,0 +1,277 @@
+// Package outbound is the HTTP client calls to other services go through. Each
+// upstream gets its own Client, which
+//
+//   - gives up on a call after the upstream's timeout,
+//   - retries idempotent calls that failed, waiting a jittered backoff in between,
+//   - stops calling an upstream that keeps failing until it has had time to
+//     recover, then lets a single probe through to check, and
+//   - caps how many calls to the upstream can be in flight at once, so a slow
+//     upstream can't tie up every goroutine.
+//
+// The settings come from the upstream's config.Upstream.
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/DaffaJatmiko/shared/config"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults for settings an upstream leaves at zero.
+const (
+	DefaultAttempts         = 3
+	DefaultMaxConcurrent    = 64
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// baseBackoff is the longest wait before the first retry; it doubles for each one after.
+const baseBackoff = 100 * time.Millisecond
+
+var (
+	// ErrCircuitOpen is returned without calling an upstream that has been failing.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+	// ErrBulkheadFull is returned without calling an upstream that already has
+	// as many calls in flight as it is allowed.
+	ErrBulkheadFull = errors.New("too many calls in flight")
+)
+
+// Client calls one upstream.
+type Client struct {
+	name     string
+	upstream config.Upstream
+	http     *http.Client
+	attempts int
+	slots    chan struct{}
+	breaker  *breaker
+}
+
+// New returns a client for the upstream called name. Requests are sent through
+// http.DefaultTransport as it is when they are made.
+func New(name string, upstream config.Upstream) *Client {
+	attempts := upstream.Attempts
+	if attempts == 0 {
+		attempts = DefaultAttempts
+	}
+	maxConcurrent := upstream.MaxConcurrent
+	if maxConcurrent == 0 {
+		maxConcurrent = DefaultMaxConcurrent
+	}
+	threshold := upstream.BreakerThreshold
+	if threshold == 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	cooldown := upstream.BreakerCooldown.Duration
+	if cooldown == 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+
+	return &Client{
+		name:     name,
+		upstream: upstream,
+		http:     &http.Client{Timeout: upstream.Timeout.Duration},
+		attempts: attempts,
+		slots:    make(chan struct{}, maxConcurrent),
+		breaker:  &breaker{threshold: threshold, cooldown: cooldown, state: Closed},
+	}
+}
+
+// Endpoint returns the URL of path on the upstream's HTTP API.
+func (c *Client) Endpoint(path string) string {
+	return c.upstream.Endpoint(path)
+}
+
+// Get fetches path from the upstream.
+func (c *Client) Get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.Endpoint(path), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// Do sends req. Transport errors and 5xx responses count as failures towards the
+// circuit breaker; GET, HEAD, OPTIONS, PUT and DELETE requests are retried after
+// transport errors and 502, 503 and 504 responses. The response from the last
+// attempt is returned, whatever its status. Each call is counted in the
+// upstream metrics with how it turned out.
+func (c *Client) Do(req *http.Request) (response *http.Response, err error) {
+	// one span covers every attempt, so time spent waiting between them shows
+	ctx, span := tracing.Tracer().Start(req.Context(), "outbound "+c.name,
+		trace.WithAttributes(attribute.String("outbound.upstream", c.name)))
+	start := time.Now()
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+		metrics.UpstreamCall(c.name, outcome(response, err), time.Since(start))
+	}()
+	req = req.WithContext(ctx)
+
+	select {
+	case c.slots <- struct{}{}:
+	default:
+		return nil, fmt.Errorf("%s: %w", c.name, ErrBulkheadFull)
+	}
+	defer func() { <-c.slots }()
+
+	retryable := idempotent(req.Method) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+
+	for attempt := 1; ; attempt++ {
+		probe, ok := c.breaker.allow()
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", c.name, ErrCircuitOpen)
+		}
+
+		try := req
+		if attempt > 1 {
+			try, err = rewind(req)
+			if err != nil {
+				c.breaker.release(probe)
+				return nil, err
+			}
+		}
+
+		span.SetAttributes(attribute.Int("outbound.attempts", attempt))
+		response, err = c.http.Do(try)
+		c.breaker.record(probe, err == nil && response.StatusCode < http.StatusInternalServerError)
+
+		if !retryable || attempt >= c.attempts || !shouldRetry(response, err) {
+			return response, err
+		}
+
+		if response != nil {
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		metrics.UpstreamRetry(c.name)
+
+		select {
+		case <-time.After(backoff(attempt)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// Status reports the client's circuit breaker and how busy it is.
+func (c *Client) Status() Status {
+	s := c.breaker.status()
+	s.Upstream = c.name
+	s.InFlight = len(c.slots)
+	s.MaxConcurrent = cap(c.slots)
+	return s
+}
+
+// Status describes one upstream as seen by its client.
+type Status struct {
+	Upstream string `json:"upstream"`
+	State    State  `json:"state"`
+	// Failures is how many calls in a row have failed.
+	Failures int `json:"consecutive_failures"`
+	// RetryAt is when an open breaker will let a probe through.
+	RetryAt       *time.Time `json:"retry_at,omitempty"`
+	InFlight      int        `json:"in_flight"`
+	MaxConcurrent int        `json:"max_concurrent"`
+}
+
+// Clients holds a client for each upstream with an HTTP API.
+type Clients struct {
+	clients map[string]*Client
+}
+
+// NewClients makes a client for every upstream in cfg that has a URL.
+func NewClients(cfg *config.Config) *Clients {
+	clients := &Clients{clients: make(map[string]*Client)}
+	for name, u := range cfg.Upstreams {
+		if u.URL != "" {
+			clients.clients[name] = New(name, u)
+		}
+	}
+	return clients
+}
+
+// Get returns the client for the upstream called name, or nil if it has no HTTP API.
+func (c *Clients) Get(name string) *Client {
+	return c.clients[name]
+}
+
+// Status reports every client, sorted by upstream.
+func (c *Clients) Status() []Status {
+	statuses := make([]Status, 0, len(c.clients))
+	for _, client := range c.clients {
+		statuses = append(statuses, client.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Upstream < statuses[j].Upstream
+	})
+
+	return statuses
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func shouldRetry(response *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// outcome says how a call that ended with response and err turned out, for the
+// upstream metrics.
+func outcome(response *http.Response, err error) string {
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return metrics.CircuitOpen
+	case errors.Is(err, ErrBulkheadFull):
+		return metrics.BulkheadFull
+	case err != nil, response.StatusCode >= http.StatusInternalServerError:
+		return metrics.Failure
+	}
+	return metrics.Success
+}
+
+// rewind returns a copy of req with a fresh body to send again.
+func rewind(req *http.Request) (*http.Request, error) {
+	try := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		try.Body = body
+	}
+	return try, nil
+}
+
+// backoff picks how long to wait before retrying after the given attempt: a
+// random time up to baseBackoff doubled for each earlier retry, so clients that
+// failed together don't all retry together.
+func backoff(attempt int) time.Duration {
+	ceiling := baseBackoff << (attempt - 1)
+	return time.Duration(rand.Int63n(int64(ceiling))) + 1
+}
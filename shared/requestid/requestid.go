@@ -0,0 +1,106 @@
+// Package requestid carries the id of the request a piece of work was done for
+// from service to service, so the log entries from every service that handled it
+// can be found together.
+//
+// The broker picks the id, or keeps the one its caller sent, and each service hands
+// it on: in the X-Request-ID header on HTTP calls, in x-request-id gRPC metadata,
+// and in the x-request-id header of RabbitMQ messages.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	// Header is the HTTP header the id travels in.
+	Header = "X-Request-ID"
+	// MetadataKey is the gRPC metadata key the id travels in.
+	MetadataKey = "x-request-id"
+	// AMQPHeader is the RabbitMQ message header the id travels in.
+	AMQPHeader = "x-request-id"
+)
+
+// maxLength bounds ids we accept from elsewhere, so a caller can't make us store
+// something huge with every log entry.
+const maxLength = 128
+
+type contextKey struct{}
+
+// New returns a new random id.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether id is one we'll pass on: not empty, not too long, and made
+// only of letters, digits and the punctuation ids commonly use.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the id ctx carries, or "" if it has none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware keeps the id the request arrived with, or makes a new one if it came
+// without a usable one. The id is stored in the request context and sent back in
+// the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = New()
+		}
+
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// Transport returns a RoundTripper that sends the id carried by each request's
+// context on to base. Requests that already have the header keep it.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return roundTripper{base: base}
+}
+
+type roundTripper struct {
+	base http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := FromContext(req.Context())
+	if id == "" || req.Header.Get(Header) != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers mustn't change the request they were given
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, id)
+
+	return t.base.RoundTrip(req)
+}
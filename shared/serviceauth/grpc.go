@@ -0,0 +1,96 @@
+package serviceauth
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// metadataKey carries a service token on gRPC calls.
+const metadataKey = "x-service-token"
+
+// Credentials returns call credentials that send a token for the service named
+// audience with every gRPC call.
+func (s *Service) Credentials(audience string) credentials.PerRPCCredentials {
+	return &perRPCCredentials{service: s, audience: audience}
+}
+
+type perRPCCredentials struct {
+	service  *Service
+	audience string
+}
+
+func (c *perRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.service.Token(c.audience)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{metadataKey: token}, nil
+}
+
+// RequireTransportSecurity is false because the services talk over the internal
+// network without TLS.
+func (c *perRPCCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// UnaryInterceptor rejects unary calls that don't carry a valid token from an
+// allowed caller.
+func (s *Service) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := s.authorize(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor is UnaryInterceptor for streaming calls. The token is checked
+// once, when the stream opens.
+func (s *Service) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := s.authorize(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (s *Service) authorize(ctx context.Context) (context.Context, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(metadataKey); len(values) > 0 {
+			token = values[0]
+		}
+	}
+
+	caller, err := s.Verify(token)
+	if err != nil {
+		if errors.Is(err, ErrCallerNotAllowed) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return context.WithValue(ctx, contextKey{}, caller), nil
+}
+
+// serverStream swaps in the context carrying the caller's name.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
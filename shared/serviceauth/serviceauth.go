@@ -0,0 +1,205 @@
+// Package serviceauth lets the internal services prove who they are to each other.
+//
+// A caller signs a short-lived token naming itself as the subject and the service it
+// is calling as the audience, and sends it in the X-Service-Token header (or the
+// x-service-token gRPC metadata key). The receiving service checks the signature,
+// that the token was meant for it, and that the caller is on its list of services
+// allowed to call it.
+//
+// Every service signs with the same secret, SERVICE_TOKEN_SECRET, so a service
+// holding it could claim to be any other; the tokens keep out callers that don't
+// hold it, such as anything else that can reach the internal network.
+package serviceauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// Header carries a service token on HTTP requests.
+	Header = "X-Service-Token"
+	// TokenTTL is how long a service token is accepted for after it is signed.
+	TokenTTL = time.Minute
+)
+
+// internalServices are the services that check service tokens, which under
+// docker-compose are also their host names. Requests to any other host go out
+// without a token, so tokens never leave the internal network.
+var internalServices = map[string]bool{
+	"authentication-service": true,
+	"logger-service":         true,
+	"task-service":           true,
+}
+
+var (
+	ErrMissingToken     = errors.New("missing service token")
+	ErrInvalidToken     = errors.New("invalid service token")
+	ErrCallerNotAllowed = errors.New("service is not allowed to call this service")
+)
+
+// Service signs the tokens one service sends and checks the tokens it receives.
+type Service struct {
+	Name    string
+	secret  []byte
+	callers map[string]bool
+	// upstreams maps the host:port of internal services reached at an address
+	// other than their name to that name.
+	upstreams map[string]string
+}
+
+// New returns the identity of the service called name, which accepts calls from the
+// services named in callers.
+func New(name, secret string, callers ...string) (*Service, error) {
+	if secret == "" {
+		return nil, errors.New("service token secret is not set")
+	}
+
+	s := &Service{
+		Name:      name,
+		secret:    []byte(secret),
+		callers:   make(map[string]bool),
+		upstreams: make(map[string]string),
+	}
+	for _, c := range callers {
+		s.callers[c] = true
+	}
+
+	return s, nil
+}
+
+// FromEnv is New using the secret in SERVICE_TOKEN_SECRET.
+func FromEnv(name string, callers ...string) (*Service, error) {
+	return New(name, os.Getenv("SERVICE_TOKEN_SECRET"), callers...)
+}
+
+// Token signs a token for calling the service named audience.
+func (s *Service) Token(audience string) (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Issuer:    s.Name,
+		Subject:   s.Name,
+		Audience:  jwt.ClaimStrings{audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(TokenTTL)),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+// Verify checks a token sent to this service and returns the name of the service
+// that sent it.
+func (s *Service) Verify(tokenString string) (string, error) {
+	if tokenString == "" {
+		return "", ErrMissingToken
+	}
+
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	// tokens without an expiry would be valid forever
+	if claims.ExpiresAt == nil || !claims.VerifyAudience(s.Name, true) {
+		return "", ErrInvalidToken
+	}
+
+	if !s.callers[claims.Subject] {
+		return "", fmt.Errorf("%w: %s", ErrCallerNotAllowed, claims.Subject)
+	}
+
+	return claims.Subject, nil
+}
+
+type contextKey struct{}
+
+// FromContext returns the name of the service that made a request accepted by
+// Middleware or the gRPC interceptors.
+func FromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(contextKey{}).(string)
+	return caller
+}
+
+// Middleware rejects requests that don't carry a valid token from an allowed caller.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, err := s.Verify(r.Header.Get(Header))
+		if err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrCallerNotAllowed) {
+				status = http.StatusForbidden
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   true,
+				"message": err.Error(),
+			})
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, caller)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AddUpstream tells the Transport that the internal service called name is reached
+// at baseURL, so requests there carry a token for it. Services that don't check
+// tokens are ignored. It must be called before the Transport is used.
+func (s *Service) AddUpstream(name, baseURL string) error {
+	if !internalServices[name] {
+		return nil
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+
+	s.upstreams[u.Host] = name
+	return nil
+}
+
+// Transport wraps base so that requests to internal services carry a token for the
+// service being called.
+func (s *Service) Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{service: s, base: base}
+}
+
+type transport struct {
+	service *Service
+	base    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	audience, ok := t.service.upstreams[r.URL.Host]
+	if !ok && internalServices[r.URL.Hostname()] {
+		audience, ok = r.URL.Hostname(), true
+	}
+	if !ok || r.Header.Get(Header) != "" {
+		return t.base.RoundTrip(r)
+	}
+
+	token, err := t.service.Token(audience)
+	if err != nil {
+		return nil, err
+	}
+
+	// a RoundTripper mustn't change the request it was given
+	r = r.Clone(r.Context())
+	r.Header.Set(Header, token)
+
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,177 @@
+// Package tracing records OpenTelemetry spans for the work a service does and
+// passes the trace on to the services it calls, so the time a request spent in
+// each of them can be seen in one place.
+//
+// Where spans go is picked with OTEL_TRACES_EXPORTER:
+//
+//   - "otlp" sends them over gRPC to the collector at OTEL_EXPORTER_OTLP_ENDPOINT,
+//     configured with the usual OTEL_EXPORTER_OTLP_* variables,
+//   - "stdout" prints them,
+//   - "file" appends them to OTEL_TRACES_FILE, one JSON document per span, and
+//   - "none" drops them.
+//
+// Left unset, it is "otlp" when an OTLP endpoint is set and "none" otherwise. The
+// trace context is passed on either way, so a service without an exporter doesn't
+// break the traces of the services around it.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// instrumentation names the spans this service starts itself. Setup sets it from
+// the service's name.
+var instrumentation = "github.com/DaffaJatmiko/shared"
+
+// Setup installs the exporter picked by the environment for the service called
+// name. The returned function flushes spans still waiting to be sent and should be
+// called before the service exits.
+func Setup(name string) (func(context.Context) error, error) {
+	instrumentation = "github.com/DaffaJatmiko/" + name
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, closer, err := newExporter()
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(name)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// newExporter returns the exporter OTEL_TRACES_EXPORTER asks for, or nil if spans
+// shouldn't be kept, along with a file to close when done.
+func newExporter() (sdktrace.SpanExporter, *os.File, error) {
+	kind := os.Getenv("OTEL_TRACES_EXPORTER")
+	if kind == "" {
+		kind = "none"
+		if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+			kind = "otlp"
+		}
+	}
+
+	switch kind {
+	case "none":
+		return nil, nil, nil
+	case "otlp":
+		exporter, err := otlptracegrpc.New(context.Background())
+		return exporter, nil, err
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, nil, err
+	case "file":
+		path := os.Getenv("OTEL_TRACES_FILE")
+		if path == "" {
+			return nil, nil, errors.New("OTEL_TRACES_FILE must be set for the file exporter")
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", kind)
+	}
+}
+
+// Tracer returns the tracer for spans the service starts itself.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Middleware starts a span for each request, continuing the trace the caller
+// sent if there is one. Once the router has matched the request the span is
+// named after the route, so requests for the same route are grouped together.
+func Middleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		span := trace.SpanFromContext(r.Context())
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				span.SetName(r.Method + " " + pattern)
+				span.SetAttributes(semconv.HTTPRoute(pattern))
+			}
+		}
+		if id := requestid.FromContext(r.Context()); id != "" {
+			span.SetAttributes(attribute.String("request.id", id))
+		}
+	})
+
+	return otelhttp.NewHandler(named, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}))
+}
+
+// Transport returns a RoundTripper that records a span for each request sent
+// through base and passes the trace on in its headers.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// ServerOption records a span for each gRPC call a server handles.
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler())
+}
+
+// DialOption records a span for each gRPC call made over a connection and passes
+// the trace on in its metadata.
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
+
+// RecordError marks span as failed with err, if there is one.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/DaffaJatmiko/task-service/event"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// listenForUserEvents removes the tasks of deleted users. It reconnects to RabbitMQ
+// whenever the connection is lost and never returns.
+func (app *Config) listenForUserEvents() {
+	for {
+		conn, err := connectToRabbit(app.Upstreams.RabbitMQ.DialURL())
+		if err != nil {
+			log.Println("Can't reach RabbitMQ", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		consumer, err := event.NewConsumer(conn)
+		if err == nil {
+			err = consumer.ListenForDeletions(app.deleteUserTasks)
+		}
+		if err != nil {
+			log.Println("Error listening for user events", err)
+			time.Sleep(5 * time.Second)
+		}
+
+		conn.Close()
+	}
+}
+
+func (app *Config) deleteUserTasks(userID int) error {
+	n, err := app.Models.Task.DeleteByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleted %d tasks of deleted user %d", n, userID)
+	return nil
+}
+
+// connectToRabbit dials RabbitMQ, backing off between attempts.
+func connectToRabbit(rabbitURL string) (*amqp.Connection, error) {
+	var counts int64
+	var backoff = 1 * time.Second
+
+	for {
+		c, err := amqp.Dial(rabbitURL)
+		if err == nil {
+			log.Println("Connected to RabbitMQ")
+			return c, nil
+		}
+
+		log.Println("RabbitMQ not yet ready")
+		counts++
+
+		if counts > 5 {
+			return nil, err
+		}
+
+		backoff = time.Duration(math.Pow(float64(counts), 2)) * time.Second
+		log.Printf("backing off %v", backoff)
+		time.Sleep(backoff)
+	}
+}
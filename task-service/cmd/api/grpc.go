@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/tracing"
+	"github.com/DaffaJatmiko/task-service/data"
+	"github.com/DaffaJatmiko/task-service/tasks"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TaskWatchers fans task change events out to every open WatchTasks stream.
+type TaskWatchers struct {
+	mu          sync.Mutex
+	subscribers map[chan *tasks.TaskEvent]struct{}
+}
+
+func NewTaskWatchers() *TaskWatchers {
+	return &TaskWatchers{
+		subscribers: make(map[chan *tasks.TaskEvent]struct{}),
+	}
+}
+
+func (tw *TaskWatchers) Subscribe() chan *tasks.TaskEvent {
+	ch := make(chan *tasks.TaskEvent, 16)
+
+	tw.mu.Lock()
+	tw.subscribers[ch] = struct{}{}
+	tw.mu.Unlock()
+
+	return ch
+}
+
+func (tw *TaskWatchers) Unsubscribe(ch chan *tasks.TaskEvent) {
+	tw.mu.Lock()
+	delete(tw.subscribers, ch)
+	tw.mu.Unlock()
+}
+
+// Publish sends an event to all subscribers. A subscriber that is not keeping up
+// misses the event rather than blocking the writer.
+func (tw *TaskWatchers) Publish(eventType tasks.TaskEvent_Type, task data.Task) {
+	event := &tasks.TaskEvent{
+		Type: eventType,
+		Task: taskToPB(task),
+	}
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	for ch := range tw.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Println("Dropping task event for slow watcher")
+		}
+	}
+}
+
+type TaskServer struct {
+	tasks.UnimplementedTaskServiceServer
+	Models   data.Models
+	Watchers *TaskWatchers
+}
+
+// errNoWorkspaceGRPC is returned for requests that don't say which workspace they are for.
+var errNoWorkspaceGRPC = status.Error(codes.InvalidArgument, "workspaceId is required")
+
+func (t *TaskServer) CreateTask(ctx context.Context, req *tasks.CreateTaskRequest) (*tasks.TaskResponse, error) {
+	if req.GetWorkspaceId() == 0 {
+		return nil, errNoWorkspaceGRPC
+	}
+
+	newID, err := t.Models.Task.Insert(data.Task{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		UserID:      int(req.GetUserId()),
+		WorkspaceID: int(req.GetWorkspaceId()),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "unable to create task")
+	}
+
+	task, err := t.Models.Task.GetOne(newID, int(req.GetWorkspaceId()))
+	if err != nil {
+		return nil, taskError(err)
+	}
+
+	t.Watchers.Publish(tasks.TaskEvent_CREATED, *task)
+
+	return &tasks.TaskResponse{Task: taskToPB(*task)}, nil
+}
+
+func (t *TaskServer) GetTask(ctx context.Context, req *tasks.GetTaskRequest) (*tasks.TaskResponse, error) {
+	if req.GetWorkspaceId() == 0 {
+		return nil, errNoWorkspaceGRPC
+	}
+
+	task, err := t.Models.Task.GetOne(int(req.GetId()), int(req.GetWorkspaceId()))
+	if err != nil {
+		return nil, taskError(err)
+	}
+
+	return &tasks.TaskResponse{Task: taskToPB(*task)}, nil
+}
+
+func (t *TaskServer) UpdateTask(ctx context.Context, req *tasks.UpdateTaskRequest) (*tasks.TaskResponse, error) {
+	input := req.GetTask()
+	if input == nil {
+		return nil, status.Error(codes.InvalidArgument, "task is required")
+	}
+	if input.GetWorkspaceId() == 0 {
+		return nil, errNoWorkspaceGRPC
+	}
+
+	// make sure the task exists in the workspace before updating it
+	if _, err := t.Models.Task.GetOne(int(input.GetId()), int(input.GetWorkspaceId())); err != nil {
+		return nil, taskError(err)
+	}
+
+	err := t.Models.Task.Update(&data.Task{
+		ID:          int(input.GetId()),
+		Name:        input.GetName(),
+		Description: input.GetDescription(),
+		UserID:      int(input.GetUserId()),
+		WorkspaceID: int(input.GetWorkspaceId()),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "unable to update task")
+	}
+
+	task, err := t.Models.Task.GetOne(int(input.GetId()), int(input.GetWorkspaceId()))
+	if err != nil {
+		return nil, taskError(err)
+	}
+
+	t.Watchers.Publish(tasks.TaskEvent_UPDATED, *task)
+
+	return &tasks.TaskResponse{Task: taskToPB(*task)}, nil
+}
+
+func (t *TaskServer) DeleteTask(ctx context.Context, req *tasks.DeleteTaskRequest) (*tasks.DeleteTaskResponse, error) {
+	if req.GetWorkspaceId() == 0 {
+		return nil, errNoWorkspaceGRPC
+	}
+
+	task, err := t.Models.Task.GetOne(int(req.GetId()), int(req.GetWorkspaceId()))
+	if err != nil {
+		return nil, taskError(err)
+	}
+
+	err = t.Models.Task.Delete(task.ID, task.WorkspaceID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "unable to delete task")
+	}
+
+	t.Watchers.Publish(tasks.TaskEvent_DELETED, *task)
+
+	return &tasks.DeleteTaskResponse{Result: fmt.Sprintf("deleted task %d", task.ID)}, nil
+}
+
+func (t *TaskServer) ListTasks(ctx context.Context, req *tasks.ListTasksRequest) (*tasks.ListTasksResponse, error) {
+	if req.GetWorkspaceId() == 0 {
+		return nil, errNoWorkspaceGRPC
+	}
+
+	filter := data.TaskFilter{
+		WorkspaceID: int(req.GetWorkspaceId()),
+		UserID:      int(req.GetUserId()),
+		Search:      req.GetSearch(),
+		Limit:       int(req.GetLimit()),
+		Offset:      int(req.GetOffset()),
+	}
+
+	if req.GetCreatedAfter() != nil {
+		filter.CreatedAfter = req.GetCreatedAfter().AsTime()
+	}
+
+	if req.GetCreatedBefore() != nil {
+		filter.CreatedBefore = req.GetCreatedBefore().AsTime()
+	}
+
+	all, err := t.Models.Task.List(filter)
+	if err != nil {
+		return nil, taskError(err)
+	}
+
+	res := &tasks.ListTasksResponse{}
+	for _, task := range all {
+		res.Tasks = append(res.Tasks, taskToPB(task))
+	}
+
+	return res, nil
+}
+
+func (t *TaskServer) WatchTasks(req *tasks.WatchTasksRequest, stream tasks.TaskService_WatchTasksServer) error {
+	if req.GetWorkspaceId() == 0 {
+		return errNoWorkspaceGRPC
+	}
+
+	events := t.Watchers.Subscribe()
+	defer t.Watchers.Unsubscribe(events)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			if event.GetTask().GetWorkspaceId() != req.GetWorkspaceId() {
+				continue
+			}
+			if req.GetUserId() > 0 && event.GetTask().GetUserId() != req.GetUserId() {
+				continue
+			}
+
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func taskToPB(task data.Task) *tasks.Task {
+	return &tasks.Task{
+		Id:          int64(task.ID),
+		Name:        task.Name,
+		Description: task.Description,
+		UserId:      int64(task.UserID),
+		WorkspaceId: int64(task.WorkspaceID),
+		CreatedAt:   timestamppb.New(task.CreatedAt),
+		UpdatedAt:   timestamppb.New(task.UpdatedAt),
+	}
+}
+
+// taskError turns an error from the data layer into a gRPC status. Database errors
+// are logged here rather than sent, since they can say more than callers should see.
+func taskError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Error(codes.NotFound, "task not found")
+	}
+
+	log.Println("Error handling gRPC call", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (app *Config) gRPCListen() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRpcPort))
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+
+	s := grpc.NewServer(
+		tracing.ServerOption(),
+		grpc.ChainUnaryInterceptor(requestid.UnaryServerInterceptor(), metrics.UnaryServerInterceptor(), app.ServiceAuth.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(requestid.StreamServerInterceptor(), metrics.StreamServerInterceptor(), app.ServiceAuth.StreamInterceptor()),
+	)
+
+	tasks.RegisterTaskServiceServer(s, &TaskServer{Models: app.Models, Watchers: app.Watchers})
+
+	log.Printf("gRPC server listening on %s", gRpcPort)
+
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
+	}
+}
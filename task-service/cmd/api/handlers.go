@@ -2,45 +2,58 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/DaffaJatmiko/task-service/data"
+	"github.com/DaffaJatmiko/task-service/tasks"
 )
 
-func (app *Config) logRequest(name, data string) error {
+// logRequest writes an entry to the logger service for the request ctx belongs to.
+// userID is the user the entry is about; the entry is erased along with their data.
+func (app *Config) logRequest(ctx context.Context, userID int, name, data string) error {
 	var entry struct {
-		Name string `json:"name"`
-		Data string `json:"data"`
+		Name   string `json:"name"`
+		Data   string `json:"data"`
+		UserID int    `json:"user_id,omitempty"`
 	}
 
 	entry.Name = name
 	entry.Data = data
+	entry.UserID = userID
 
 	jsonData, err := json.MarshalIndent(entry, "", "\t")
-	logServiceUrl := "http://logger-service/log"
+	logger := app.Outbound.Get(loggerService)
+	logServiceUrl := logger.Endpoint("/log")
 
-	request, err := http.NewRequest("POST", logServiceUrl, bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", logServiceUrl, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
 
-	client := &http.Client{}
-	_, err = client.Do(request)
+	response, err := logger.Do(request)
 	if err != nil {
 		return err
 	}
+	response.Body.Close()
 
 	return nil
 }
+
+// errNoWorkspace is returned for requests that don't say which workspace they are for.
+var errNoWorkspace = errors.New("workspace_id is required")
+
 // TODO: implement the get task by category method using GetTaskByUserId method in models.go
 func (app *Config) GetTask(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		UserID int `json:"user_id"`
+		UserID      int `json:"user_id"`
+		WorkspaceID int `json:"workspace_id"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -49,14 +62,19 @@ func (app *Config) GetTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	task, err := app.Models.Task.GetTasksByUserID(requestPayload.UserID)
+	if requestPayload.WorkspaceID == 0 {
+		app.errorJSON(w, errNoWorkspace, http.StatusBadRequest)
+		return
+	}
+
+	task, err := app.Models.Task.GetTasksByUserID(requestPayload.UserID, requestPayload.WorkspaceID)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
 	// log registration
-	err = app.logRequest("get task by user id", fmt.Sprintf("get task by user id: %d", requestPayload.UserID))
+	err = app.logRequest(r.Context(), requestPayload.UserID, "get task by user id", fmt.Sprintf("get task by user id: %d", requestPayload.UserID))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -76,6 +94,7 @@ func (app *Config) CreateTask(w http.ResponseWriter, r *http.Request) {
 		Name        string `json:"name"`
 		Description string `json:"description"`
 		UserID      int    `json:"user_id"`
+		WorkspaceID int    `json:"workspace_id"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -86,28 +105,37 @@ func (app *Config) CreateTask(w http.ResponseWriter, r *http.Request) {
 
 	log.Println(requestPayload)
 
+	if requestPayload.WorkspaceID == 0 {
+		app.errorJSON(w, errNoWorkspace, http.StatusBadRequest)
+		return
+	}
+
 	task := data.Task{
 		Name:        requestPayload.Name,
 		Description: requestPayload.Description,
 		UserID:      requestPayload.UserID,
+		WorkspaceID: requestPayload.WorkspaceID,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
 	log.Println(task)
 
-	_, err = app.Models.Task.Insert(task)
+	newID, err := app.Models.Task.Insert(task)
 	if err != nil {
 		app.errorJSON(w, errors.New("unable to create task"), http.StatusBadRequest)
 		return
 	}
+	task.ID = newID
 
-		// log registration
-		err = app.logRequest("create task", fmt.Sprintf("%s added", task.Name))
-		if err != nil {
-			app.errorJSON(w, err)
-			return
-		}
+	app.Watchers.Publish(tasks.TaskEvent_CREATED, task)
+
+	// log registration
+	err = app.logRequest(r.Context(), task.UserID, "create task", fmt.Sprintf("%s added by %s", task.Name, app.describeUser(r.Context(), task.UserID)))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
 	payload := jsonResponse{
 		Error:   false,
@@ -118,8 +146,76 @@ func (app *Config) CreateTask(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusCreated, payload)
 }
 
+// TaskOwner is what a task list shows about the person each task belongs to.
+type TaskOwner struct {
+	ID        int    `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+}
+
+type taskWithOwner struct {
+	*data.Task
+	Owner *TaskOwner `json:"owner,omitempty"`
+}
+
+// withOwners attaches each task's owner, looked up in the authentication service.
+// The tasks are still worth returning if that fails, so they go out without owners.
+func (app *Config) withOwners(ctx context.Context, tasks []*data.Task) []taskWithOwner {
+	ids := make([]int, 0, len(tasks))
+	for _, t := range tasks {
+		ids = append(ids, t.UserID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, app.Users.Timeout)
+	defer cancel()
+
+	owners, err := app.Users.GetMany(ctx, ids)
+	if err != nil {
+		log.Println("Error looking up task owners", err)
+	}
+
+	result := make([]taskWithOwner, 0, len(tasks))
+	for _, t := range tasks {
+		entry := taskWithOwner{Task: t}
+		if u, ok := owners[t.UserID]; ok {
+			entry.Owner = &TaskOwner{
+				ID:        int(u.GetId()),
+				Email:     u.GetEmail(),
+				FirstName: u.GetFirstName(),
+				LastName:  u.GetLastName(),
+			}
+		}
+		result = append(result, entry)
+	}
+
+	return result
+}
+
+// describeUser names a user for log messages, by email when the authentication
+// service can tell us it and by id otherwise.
+func (app *Config) describeUser(ctx context.Context, id int) string {
+	ctx, cancel := context.WithTimeout(ctx, app.Users.Timeout)
+	defer cancel()
+
+	u, err := app.Users.Get(ctx, id)
+	if err != nil {
+		log.Println("Error looking up user", id, err)
+		return fmt.Sprintf("user %d", id)
+	}
+
+	return u.GetEmail()
+}
+
+// GetTasks returns every task in the workspace named by the workspace_id query parameter.
 func (app *Config) GetTasks(w http.ResponseWriter, r *http.Request) {
-	tasks, err := app.Models.Task.GetAll()
+	workspaceID, err := strconv.Atoi(r.URL.Query().Get("workspace_id"))
+	if err != nil || workspaceID == 0 {
+		app.errorJSON(w, errNoWorkspace, http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := app.Models.Task.GetAll(workspaceID)
 	if err != nil {
 		app.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -128,52 +224,66 @@ func (app *Config) GetTasks(w http.ResponseWriter, r *http.Request) {
 	payload := jsonResponse{
 		Error:   false,
 		Message: "Success",
-		Data:    tasks,
+		Data:    app.withOwners(r.Context(), tasks),
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-
 func (app *Config) UpdateTask(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-			ID          int    `json:"id"`
-			Name        string `json:"name"`
-			Description string `json:"description"`
-			UserID      int    `json:"user_id"`
+		ID          int    `json:"id"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		UserID      int    `json:"user_id"`
+		WorkspaceID int    `json:"workspace_id"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-			app.errorJSON(w, err, http.StatusBadRequest)
-			return
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.WorkspaceID == 0 {
+		app.errorJSON(w, errNoWorkspace, http.StatusBadRequest)
+		return
+	}
+
+	// tasks in other workspaces are invisible, as if they didn't exist
+	if _, err := app.Models.Task.GetOne(requestPayload.ID, requestPayload.WorkspaceID); err != nil {
+		app.errorJSON(w, errors.New("task not found"), http.StatusNotFound)
+		return
 	}
 
 	task := data.Task{
-			ID:          requestPayload.ID,
-			Name:        requestPayload.Name,
-			Description: requestPayload.Description,
-			UserID:      requestPayload.UserID,
-			UpdatedAt:   time.Now(),
+		ID:          requestPayload.ID,
+		Name:        requestPayload.Name,
+		Description: requestPayload.Description,
+		UserID:      requestPayload.UserID,
+		WorkspaceID: requestPayload.WorkspaceID,
+		UpdatedAt:   time.Now(),
 	}
 
 	err = app.Models.Task.Update(&task) // Pass the task pointer to the Update method
 	if err != nil {
-			app.errorJSON(w, errors.New("unable to update task"), http.StatusBadRequest)
-			return
+		app.errorJSON(w, errors.New("unable to update task"), http.StatusBadRequest)
+		return
 	}
 
+	app.Watchers.Publish(tasks.TaskEvent_UPDATED, task)
+
 	// log registration
-	err = app.logRequest("update task", fmt.Sprintf("%s updated", task.Name))
+	err = app.logRequest(r.Context(), task.UserID, "update task", fmt.Sprintf("%s updated", task.Name))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
-	
+
 	payload := jsonResponse{
-			Error:    false,
-			Message:  fmt.Sprintf("Updated task %s", task.Name),
-			Data:     task,
+		Error:   false,
+		Message: fmt.Sprintf("Updated task %s", task.Name),
+		Data:    task,
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
@@ -181,7 +291,8 @@ func (app *Config) UpdateTask(w http.ResponseWriter, r *http.Request) {
 
 func (app *Config) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		ID int `json:"id"`
+		ID          int `json:"id"`
+		WorkspaceID int `json:"workspace_id"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -190,26 +301,39 @@ func (app *Config) DeleteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	task := data.Task{ID: requestPayload.ID}
+	if requestPayload.WorkspaceID == 0 {
+		app.errorJSON(w, errNoWorkspace, http.StatusBadRequest)
+		return
+	}
 
-	err = task.Delete(requestPayload.ID)
+	// keep the owner around so watchers filtering by user still see the delete
+	existing, err := app.Models.Task.GetOne(requestPayload.ID, requestPayload.WorkspaceID)
+	if err != nil {
+		app.errorJSON(w, errors.New("task not found"), http.StatusNotFound)
+		return
+	}
+	task := *existing
+
+	err = task.Delete(task.ID, task.WorkspaceID)
 	if err != nil {
 		app.errorJSON(w, errors.New("unable to delete task"), http.StatusBadRequest)
 		return
 	}
 
+	app.Watchers.Publish(tasks.TaskEvent_DELETED, task)
+
 	// log registration
-	err = app.logRequest("delete task", fmt.Sprintf("%d deleted", requestPayload.ID))
+	err = app.logRequest(r.Context(), task.UserID, "delete task", fmt.Sprintf("%d deleted", requestPayload.ID))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
 	payload := jsonResponse{
-		Error:    false,
-		Message:  fmt.Sprintf("deleted task %d", requestPayload.ID),
-		Data:     task,
-}
+		Error:   false,
+		Message: fmt.Sprintf("deleted task %d", requestPayload.ID),
+		Data:    task,
+	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
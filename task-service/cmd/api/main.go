@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -8,35 +9,95 @@ import (
 	"os"
 	"time"
 
+	"github.com/DaffaJatmiko/shared/config"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/outbound"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/serviceauth"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"github.com/DaffaJatmiko/task-service/data"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
 )
 
-const webPort = "80"
+const (
+	webPort  = "80"
+	gRpcPort = "50001"
+)
 
 type Config struct {
-    DB     *sql.DB
-    Models data.Models
+	DB          *sql.DB
+	Models      data.Models
+	Watchers    *TaskWatchers
+	ServiceAuth *serviceauth.Service
+	Users       *UserDirectory
+	Upstreams   *config.Config
+	Outbound    *outbound.Clients
 }
 
 func main() {
+	// find out where the other services are, and stop now if that can't work
+	upstreams, err := config.Load(os.Getenv("CONFIG_FILE"), defaultConfig)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	shutdownTracing, err := tracing.Setup("task-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// the broker serves tasks to users and the authentication service exports them
+	serviceAuth, err := serviceauth.FromEnv("task-service", "broker-service", "authentication-service")
+	if err != nil {
+		log.Panic(err)
+	}
+	err = serviceAuth.AddUpstream(loggerService, upstreams.Upstream(loggerService).URL)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// calls to the logger service carry a service token and the request id
+	http.DefaultTransport = tracing.Transport(requestid.Transport(serviceAuth.Transport(http.DefaultTransport)))
+
+	userDirectory, err := NewUserDirectory(upstreams.Upstream(authService), serviceAuth)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	conn := connectToDB()
 	if conn == nil {
 		log.Panic("Can't connect to MySQL!")
 	}
 
+	// the connection pool's stats are served with the other metrics
+	err = metrics.Setup("task-service", collectors.NewDBStatsCollector(conn, "tasks"))
+	if err != nil {
+		log.Panic(err)
+	}
+
 	app := Config{
-		DB:     conn,
-		Models: data.New(conn),
+		DB:          conn,
+		Models:      data.New(conn),
+		Watchers:    NewTaskWatchers(),
+		ServiceAuth: serviceAuth,
+		Users:       userDirectory,
+		Upstreams:   upstreams,
+		Outbound:    outbound.NewClients(upstreams),
 	}
 
+	go app.gRPCListen()
+	go app.listenForUserEvents()
+
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", webPort),
 		Handler: app.routes(),
 	}
 
 	log.Printf("Starting server on port %s\n", webPort)
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if err != nil {
 		log.Panic(err)
 	}
@@ -45,7 +106,7 @@ func main() {
 func connectToDB() *sql.DB {
 	dsn := os.Getenv("DSN")
 	for {
-		db, err := sql.Open("mysql", dsn)
+		db, err := tracing.OpenDB("mysql", dsn, semconv.DBSystemMySQL)
 		if err != nil {
 			log.Println("MySQL not yet ready...")
 		} else {
@@ -3,6 +3,9 @@ package main
 import (
 	"net/http"
 
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -22,14 +25,19 @@ func (app *Config) routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(metrics.Endpoint("/metrics"))
+	mux.Use(requestid.Middleware)
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+	mux.Use(app.ServiceAuth.Middleware)
 
 	mux.Route("/tasks", func(r chi.Router) {
-		r.Get("/", app.GetTasks)             // GET /tasks
-		r.Post("/", app.CreateTask)          // POST /tasks
-		r.Get("/userId", app.GetTask)          // GET /tasks/{id}
-		r.Put("/update", app.UpdateTask)       // PUT /tasks/{id}
-		r.Delete("/delete", app.DeleteTask)    // DELETE /tasks/{id}
+		r.Get("/", app.GetTasks)            // GET /tasks
+		r.Post("/", app.CreateTask)         // POST /tasks
+		r.Get("/userId", app.GetTask)       // GET /tasks/{id}
+		r.Put("/update", app.UpdateTask)    // PUT /tasks/{id}
+		r.Delete("/delete", app.DeleteTask) // DELETE /tasks/{id}
 	})
 
-return mux
-}
\ No newline at end of file
+	return mux
+}
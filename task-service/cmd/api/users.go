@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/DaffaJatmiko/shared/config"
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/requestid"
+	"github.com/DaffaJatmiko/shared/serviceauth"
+	"github.com/DaffaJatmiko/shared/tracing"
+	"github.com/DaffaJatmiko/task-service/users"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// userCacheTTL bounds how long a changed name or email can take to show up.
+	userCacheTTL = time.Minute
+	// userBatchSize matches the most users the authentication service returns per call.
+	userBatchSize = 100
+)
+
+type cachedUser struct {
+	user    *users.User
+	expires time.Time
+}
+
+// UserDirectory looks users up in the authentication service and remembers the
+// answers for a short while, since task lists name the same few people over and over.
+type UserDirectory struct {
+	client users.UserServiceClient
+	// Timeout is how long a request waits for the authentication service before
+	// carrying on without the user's details.
+	Timeout time.Duration
+	mu      sync.Mutex
+	entries map[int]cachedUser
+}
+
+// NewUserDirectory sets up a connection to the authentication service. It doesn't
+// wait for the service to be up; the first lookup does.
+func NewUserDirectory(upstream config.Upstream, serviceAuth *serviceauth.Service) (*UserDirectory, error) {
+	conn, err := grpc.Dial(upstream.GRPC,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(serviceAuth.Credentials(authService)),
+		grpc.WithChainUnaryInterceptor(requestid.UnaryClientInterceptor(), metrics.UnaryClientInterceptor(authService)),
+		tracing.DialOption())
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserDirectory{
+		client:  users.NewUserServiceClient(conn),
+		Timeout: upstream.Timeout.Duration,
+		entries: make(map[int]cachedUser),
+	}, nil
+}
+
+// Get returns the user with the given id.
+func (d *UserDirectory) Get(ctx context.Context, id int) (*users.User, error) {
+	if u, ok := d.cached(id); ok {
+		return u, nil
+	}
+
+	res, err := d.client.GetUser(ctx, &users.GetUserRequest{Id: int64(id)})
+	if err != nil {
+		return nil, err
+	}
+
+	d.store(res.GetUser())
+
+	return res.GetUser(), nil
+}
+
+// GetMany returns the users with the given ids, keyed by id. Ids that don't belong
+// to a user are missing from the result.
+func (d *UserDirectory) GetMany(ctx context.Context, ids []int) (map[int]*users.User, error) {
+	found := make(map[int]*users.User)
+	var missing []int64
+
+	for _, id := range ids {
+		if _, ok := found[id]; ok {
+			continue
+		}
+		if u, ok := d.cached(id); ok {
+			found[id] = u
+			continue
+		}
+		found[id] = nil
+		missing = append(missing, int64(id))
+	}
+
+	for len(missing) > 0 {
+		batch := missing
+		if len(batch) > userBatchSize {
+			batch = batch[:userBatchSize]
+		}
+		missing = missing[len(batch):]
+
+		res, err := d.client.BatchGetUsers(ctx, &users.BatchGetUsersRequest{Ids: batch})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, u := range res.GetUsers() {
+			d.store(u)
+			found[int(u.GetId())] = u
+		}
+	}
+
+	for id, u := range found {
+		if u == nil {
+			delete(found, id)
+		}
+	}
+
+	return found, nil
+}
+
+func (d *UserDirectory) cached(id int) (*users.User, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[id]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.user, true
+}
+
+func (d *UserDirectory) store(u *users.User) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// drop stale entries so users who are no longer looked up don't pile up
+	for k, v := range d.entries {
+		if time.Now().After(v.expires) {
+			delete(d.entries, k)
+		}
+	}
+	d.entries[int(u.GetId())] = cachedUser{user: u, expires: time.Now().Add(userCacheTTL)}
+}
@@ -28,24 +28,26 @@ type Models struct {
 	Task Task
 }
 
-// Task is the structure which holds one task from the database.
+// Task is the structure which holds one task from the database. Every task belongs
+// to a workspace, and every query is limited to one workspace.
 type Task struct {
 	ID          int       `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	UserID      int       `json:"user_id"`
+	WorkspaceID int       `json:"workspace_id"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
-// GetAll returns a slice of all tasks, sorted by created_at
-func (t *Task) GetAll() ([]*Task, error) {
+// GetAll returns a slice of all tasks in a workspace, sorted by created_at
+func (t *Task) GetAll(workspaceID int) ([]*Task, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, name, description, user_id, created_at, updated_at from tasks order by created_at`
+	query := `select id, name, description, user_id, workspace_id, created_at, updated_at from tasks where workspace_id = ? order by created_at`
 
-	rows, err := db.QueryContext(ctx, query)
+	rows, err := db.QueryContext(ctx, query, workspaceID)
 	if err != nil {
 		return nil, err
 	}
@@ -60,6 +62,7 @@ func (t *Task) GetAll() ([]*Task, error) {
 			&task.Name,
 			&task.Description,
 			&task.UserID,
+			&task.WorkspaceID,
 			&task.CreatedAt,
 			&task.UpdatedAt,
 		)
@@ -74,21 +77,22 @@ func (t *Task) GetAll() ([]*Task, error) {
 	return tasks, nil
 }
 
-// GetOne returns one task by id
-func (t *Task) GetOne(id int) (*Task, error) {
+// GetOne returns one task by id, if it is in the workspace
+func (t *Task) GetOne(id, workspaceID int) (*Task, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, name, description, user_id, created_at, updated_at from tasks where id = ?`
+	query := `select id, name, description, user_id, workspace_id, created_at, updated_at from tasks where id = ? and workspace_id = ?`
 
 	var task Task
-	row := db.QueryRowContext(ctx, query, id)
+	row := db.QueryRowContext(ctx, query, id, workspaceID)
 
 	err := row.Scan(
 		&task.ID,
 		&task.Name,
 		&task.Description,
 		&task.UserID,
+		&task.WorkspaceID,
 		&task.CreatedAt,
 		&task.UpdatedAt,
 	)
@@ -100,14 +104,14 @@ func (t *Task) GetOne(id int) (*Task, error) {
 	return &task, nil
 }
 
-// GetTasksByUserID returns tasks by user ID
-func (t *Task) GetTasksByUserID(userID int) ([]Task, error) {
+// GetTasksByUserID returns a user's tasks in a workspace
+func (t *Task) GetTasksByUserID(userID, workspaceID int) ([]Task, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, name, description, user_id, created_at, updated_at from tasks where user_id = ?`
+	query := `select id, name, description, user_id, workspace_id, created_at, updated_at from tasks where user_id = ? and workspace_id = ?`
 
-	rows, err := db.QueryContext(ctx, query, userID)
+	rows, err := db.QueryContext(ctx, query, userID, workspaceID)
 	if err != nil {
 		return nil, err
 	}
@@ -122,6 +126,85 @@ func (t *Task) GetTasksByUserID(userID int) ([]Task, error) {
 			&task.Name,
 			&task.Description,
 			&task.UserID,
+			&task.WorkspaceID,
+			&task.CreatedAt,
+			&task.UpdatedAt,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, err
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// TaskFilter holds the criteria used by List. WorkspaceID is required; zero values
+// of the other fields are ignored.
+type TaskFilter struct {
+	WorkspaceID   int
+	UserID        int
+	Search        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Limit         int
+	Offset        int
+}
+
+// List returns the tasks matching the given filter, sorted by created_at
+func (t *Task) List(filter TaskFilter) ([]Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, user_id, workspace_id, created_at, updated_at from tasks where workspace_id = ?`
+	args := []any{filter.WorkspaceID}
+
+	if filter.UserID > 0 {
+		query += ` and user_id = ?`
+		args = append(args, filter.UserID)
+	}
+
+	if filter.Search != "" {
+		query += ` and (name like ? or description like ?)`
+		pattern := "%" + filter.Search + "%"
+		args = append(args, pattern, pattern)
+	}
+
+	if !filter.CreatedAfter.IsZero() {
+		query += ` and created_at >= ?`
+		args = append(args, filter.CreatedAfter)
+	}
+
+	if !filter.CreatedBefore.IsZero() {
+		query += ` and created_at < ?`
+		args = append(args, filter.CreatedBefore)
+	}
+
+	query += ` order by created_at`
+
+	if filter.Limit > 0 {
+		query += ` limit ? offset ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []Task
+
+	for rows.Next() {
+		var task Task
+		err := rows.Scan(
+			&task.ID,
+			&task.Name,
+			&task.Description,
+			&task.UserID,
+			&task.WorkspaceID,
 			&task.CreatedAt,
 			&task.UpdatedAt,
 		)
@@ -143,13 +226,14 @@ func (t *Task) Insert(task Task) (int, error) {
 
 	log.Println("Inserting task", task)
 
-	stmt := `insert into tasks (name, description, user_id, created_at, updated_at)
-		values (?, ?, ?, ?, ?)`
+	stmt := `insert into tasks (name, description, user_id, workspace_id, created_at, updated_at)
+		values (?, ?, ?, ?, ?, ?)`
 
 	res, err := db.ExecContext(ctx, stmt,
 		task.Name,
 		task.Description,
 		task.UserID,
+		task.WorkspaceID,
 		time.Now(),
 		time.Now(),
 	)
@@ -170,7 +254,7 @@ func (t *Task) Insert(task Task) (int, error) {
 }
 
 // Update updates one task in the database, using the information
-// stored in the receiver t
+// stored in task. Tasks can't be moved between workspaces.
 func (t *Task) Update(task *Task) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -182,36 +266,53 @@ func (t *Task) Update(task *Task) error {
 	description = ?,
 	user_id = ?,
 	updated_at = ?
-	where id = ?`
+	where id = ? and workspace_id = ?`
 
 	_, err := db.ExecContext(ctx, stmt,
-			task.Name,
-			task.Description,
-			task.UserID,
-			time.Now(),
-			task.ID,  
+		task.Name,
+		task.Description,
+		task.UserID,
+		time.Now(),
+		task.ID,
+		task.WorkspaceID,
 	)
 
 	if err != nil {
 		log.Println("Error updating", err)
-			return err
+		return err
 	}
 
 	log.Println("Updated", t)
 	return nil
 }
 
-// Delete deletes one task from the database, by Task.ID
-func (t *Task) Delete(id int) error {
+// Delete deletes one task from the database, by ID, if it is in the workspace
+func (t *Task) Delete(id, workspaceID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from tasks where id = ?`
+	stmt := `delete from tasks where id = ? and workspace_id = ?`
 
-	_, err := db.ExecContext(ctx, stmt, id)
+	_, err := db.ExecContext(ctx, stmt, id, workspaceID)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// DeleteByUserID deletes every task belonging to a user, in every workspace, and
+// returns how many were removed. It is only used when an account is deleted.
+func (t *Task) DeleteByUserID(userID int) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from tasks where user_id = ?`
+
+	res, err := db.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,25 @@
+-- Changes to the tasks table. MySQL has no "if not exists" for columns and indexes,
+-- so each change checks information_schema first and the script can be run again.
+
+-- Tasks belong to a workspace. Run this after the authentication service's
+-- schema.sql: that gives every existing user a personal workspace whose ID is their
+-- user ID, so existing tasks move into their owner's personal workspace.
+set @stmt = (
+    select if(count(*) = 0, 'alter table tasks add column workspace_id int not null default 0', 'do 0')
+    from information_schema.columns
+    where table_schema = database() and table_name = 'tasks' and column_name = 'workspace_id'
+);
+prepare stmt from @stmt;
+execute stmt;
+deallocate prepare stmt;
+
+update tasks set workspace_id = user_id where workspace_id = 0;
+
+set @stmt = (
+    select if(count(*) = 0, 'create index tasks_workspace_id_idx on tasks (workspace_id)', 'do 0')
+    from information_schema.statistics
+    where table_schema = database() and table_name = 'tasks' and index_name = 'tasks_workspace_id_idx'
+);
+prepare stmt from @stmt;
+execute stmt;
+deallocate prepare stmt;
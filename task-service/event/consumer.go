@@ -0,0 +1,146 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/DaffaJatmiko/shared/metrics"
+	"github.com/DaffaJatmiko/shared/tracing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// deletionsQueue collects UserDeletedEvents for the task service.
+const deletionsQueue = "task-service.user.deleted"
+
+// deletionStep is the step of an account deletion the task service is responsible for.
+const deletionStep = "tasks"
+
+type Consumer struct {
+	conn *amqp.Connection
+}
+
+func NewConsumer(conn *amqp.Connection) (Consumer, error) {
+	consumer := Consumer{
+		conn: conn,
+	}
+
+	err := consumer.setup()
+	if err != nil {
+		return Consumer{}, err
+	}
+	return consumer, nil
+}
+
+func (consumer *Consumer) setup() error {
+	channel, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	return declareExchange(channel)
+}
+
+// ListenForDeletions calls deleteUserData for every deleted user and reports back
+// once it succeeds. Failures aren't retried here; the authentication service keeps
+// publishing the deletion until we report it done. It blocks until the connection
+// closes.
+func (consumer *Consumer) ListenForDeletions(deleteUserData func(userID int) error) error {
+	ch, err := consumer.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	q, err := declareDurableQueue(ch, deletionsQueue)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(q.Name, UserDeleted, UsersExchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	messages, err := ch.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Waiting for message [Exchange, Queue] [%s, %s]\n", UsersExchange, q.Name)
+
+	for d := range messages {
+		consumer.handleDeletion(ch, q.Name, d, deleteUserData)
+	}
+
+	return nil
+}
+
+// handleDeletion handles one message from the deletions queue, within a span
+// continuing the trace the authentication service published it with.
+func (consumer *Consumer) handleDeletion(ch *amqp.Channel, queue string, d amqp.Delivery, deleteUserData func(userID int) error) {
+	ctx, span := tracing.StartConsume(context.Background(), queue, d)
+	defer span.End()
+
+	var payload UserDeletedEvent
+	if err := json.Unmarshal(d.Body, &payload); err != nil {
+		log.Println("Dropping malformed user deleted event", err)
+		tracing.RecordError(span, err)
+		metrics.Consumed(queue, err)
+		_ = d.Ack(false)
+		return
+	}
+
+	if err := deleteUserData(payload.UserID); err != nil {
+		log.Printf("Error deleting tasks of user %d: %v", payload.UserID, err)
+		tracing.RecordError(span, err)
+		metrics.Consumed(queue, err)
+		_ = d.Nack(false, false)
+		return
+	}
+
+	err := publish(ctx, ch, UserDeletionCompleted, DeletionCompletedEvent{
+		DeletionID: payload.DeletionID,
+		UserID:     payload.UserID,
+		Service:    deletionStep,
+	})
+	if err != nil {
+		log.Println("Error reporting deletion completed", err)
+	}
+
+	metrics.Consumed(queue, nil)
+	_ = d.Ack(false)
+}
+
+func publish(ctx context.Context, ch *amqp.Channel, routingKey string, payload any) (err error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	headers := amqp.Table{}
+	ctx, span := tracing.StartPublish(ctx, UsersExchange, routingKey, headers)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+		metrics.Published(UsersExchange, routingKey, err)
+	}()
+
+	return ch.PublishWithContext(ctx,
+		UsersExchange,
+		routingKey,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Headers:      headers,
+			Body:         body,
+		})
+}
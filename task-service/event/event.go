@@ -0,0 +1,55 @@
+package event
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// UsersExchange carries events about user accounts from the authentication service.
+const UsersExchange = "users_topic"
+
+// Routing keys used on UsersExchange.
+const (
+	UserDeleted           = "user.deleted"
+	UserDeletionCompleted = "user.deletion.completed"
+)
+
+// UserDeletedEvent is published by the authentication service when an account is
+// deleted.
+type UserDeletedEvent struct {
+	DeletionID int    `json:"deletion_id"`
+	UserID     int    `json:"user_id"`
+	Email      string `json:"email"`
+}
+
+// DeletionCompletedEvent tells the authentication service that our part of a
+// deletion is done.
+type DeletionCompletedEvent struct {
+	DeletionID int    `json:"deletion_id"`
+	UserID     int    `json:"user_id"`
+	Service    string `json:"service"`
+}
+
+func declareExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		UsersExchange, // name
+		"topic",       // type
+		true,          // durable
+		false,         // auto-deleted
+		false,         // internal
+		false,         // no-wait
+		nil,           // arguments
+	)
+}
+
+// declareDurableQueue declares a named queue that survives restarts, so events sent
+// while the service is down are delivered when it comes back.
+func declareDurableQueue(ch *amqp.Channel, name string) (amqp.Queue, error) {
+	return ch.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+}